@@ -1,6 +1,10 @@
 package eval
 
-import "system-transparency.org/stboot/stlog"
+import (
+	"strings"
+
+	"system-transparency.org/stboot/stlog"
+)
 
 // Helper function to map strings to log.logLevel.
 func setLoglevel(level string) {
@@ -15,3 +19,17 @@ func setLoglevel(level string) {
 		stlog.SetLevel(stlog.InfoLevel)
 	}
 }
+
+// stringList is a flag.Value for flags that can be repeated, each
+// occurrence adding one value.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+
+	return nil
+}
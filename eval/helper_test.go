@@ -13,3 +13,15 @@ func TestSetLoglevel(t *testing.T) {
 		setLoglevel(level)
 	}
 }
+
+func TestStringList(t *testing.T) {
+	var l stringList
+	for _, value := range []string{"a", "b", "a"} {
+		if err := l.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := l.String(), "a,b,a"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
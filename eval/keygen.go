@@ -4,6 +4,8 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"system-transparency.org/stboot/stlog"
@@ -30,17 +32,28 @@ func KeygenCertificate(args []string) error {
 	certificateCmd := flag.NewFlagSet("certificate", flag.ExitOnError)
 	certificateRootCert := certificateCmd.String("rootCert", "", "Root cert in PEM format to sign the new certificate."+
 		" Ignored if -isCA is set.")
-	certificateRootKey := certificateCmd.String("rootKey", "", "Root key in PEM or OpenSSH format to sign the new certificate.")
-	certificateLeafKey := certificateCmd.String("leafKey", "", "Public key to certify, in PEM or OpenSSH format.")
+	certificateRootKey := certificateCmd.String("rootKey", "", "Root key in PEM or OpenSSH format to sign the new certificate,"+
+		" or a pkcs11: URI or signer: spec, see the manual.")
+	certificateLeafKey := certificateCmd.String("leafKey", "", "Public key to certify, in PEM or OpenSSH format,"+
+		" or a pkcs11: URI or signer: spec, see the manual.")
 	certificateIsCA := certificateCmd.Bool("isCA", false, "Generate self signed root certificate.")
+	certificateIntermediate := certificateCmd.Bool("intermediate", false, "Generate an intermediate CA certificate,"+
+		" signed by -rootCert and -rootKey.")
+	certificatePathLen := certificateCmd.Int("pathLen", -1, "Path length constraint of a CA certificate:"+
+		" the number of intermediate CAs allowed below it. Defaults to none for -isCA, and 0 for -intermediate.")
 	certificateValidFrom := certificateCmd.String("validFrom", "", "Date formatted as RFC3339."+
 		" Defaults to time of creation.")
 	certificateValidUntil := certificateCmd.String("validUntil", "", "Date formatted as RFC3339."+
 		" Defaults to time of creation + 72h.")
 	certificateCertOut := certificateCmd.String("certOut", "", "Output certificate file."+
-		" Defaults to cert.pem or rootcert.pem is -isCA is set.")
+		" Defaults to cert.pem, or rootcert.pem if -isCA is set, or intermediatecert.pem if -intermediate is set.")
 	certificateKeyOut := certificateCmd.String("keyOut", "", "Output key file."+
-		" Defaults to key.pem or rootkey.pem if -isCA is set.")
+		" Defaults to key.pem, or rootkey.pem if -isCA is set, or intermediatekey.pem if -intermediate is set.")
+	certificateAlgorithm := certificateCmd.String("algorithm", "", "Algorithm of a generated key, any of ed25519 (default),"+
+		" rsa2048, rsa3072 and rsa4096. Only ed25519 can sign OS packages, rsa keys get code signing"+
+		" certificates for uki create and secureboot.")
+	certificateChainOut := certificateCmd.String("chainOut", "", "Optional output file for the certificate chain:"+
+		" the new certificate followed by any intermediate certificates in -rootCert.")
 	certificateLogLevel := certificateCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
 
 	// Parse which flags are provided to the function
@@ -60,6 +73,13 @@ func KeygenCertificate(args []string) error {
 		stlog.Debug("Registered flag %q", f)
 	})
 
+	// The default path length depends on the kind of certificate,
+	// so only pass it on if given.
+	var pathLen *int
+	if *certificatePathLen != -1 {
+		pathLen = certificatePathLen
+	}
+
 	now := time.Now()
 
 	notBefore, err := parseDate(*certificateValidFrom, now)
@@ -76,13 +96,104 @@ func KeygenCertificate(args []string) error {
 	return keygen.Certificate(
 		&keygen.CertificateArgs{
 			IsCa:           *certificateIsCA,
+			IsIntermediate: *certificateIntermediate,
+			PathLen:        pathLen,
 			IssuerCertFile: *certificateRootCert,
 			IssuerKeyFile:  *certificateRootKey,
 			LeafKeyFile:    *certificateLeafKey,
+			Algorithm:      *certificateAlgorithm,
 			NotBefore:      notBefore,
 			NotAfter:       notAfter,
 			CertOut:        *certificateCertOut,
 			KeyOut:         *certificateKeyOut,
+			ChainOut:       *certificateChainOut,
+		},
+	)
+}
+
+// KeygenKeypair takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls keygen.Keypair after they are parsed.
+func KeygenKeypair(args []string) error {
+	// Create a custom flag set and register flags
+	keypairCmd := flag.NewFlagSet("keypair", flag.ExitOnError)
+	keypairFormat := keypairCmd.String("format", keygen.FormatPEM, "Comma separated list of key formats, pem and/or openssh."+
+		" The private key is written in the first format.")
+	keypairOut := keypairCmd.String("out", "", "Output private key file. Public keys are written to"+
+		" the same name with .pub appended (openssh format) and .pub.pem appended (pem format).")
+	keypairAlgorithm := keypairCmd.String("algorithm", keygen.AlgorithmEd25519, "Key algorithm, any of ed25519,"+
+		" rsa2048, rsa3072 and rsa4096. The openssh format and -agent require ed25519.")
+	keypairAgent := keypairCmd.Bool("agent", false, "Add the private key to the ssh-agent at $SSH_AUTH_SOCK.")
+	keypairLogLevel := keypairCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := keypairCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if keypairCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*keypairLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	keypairCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return keygen.Keypair(
+		&keygen.KeypairArgs{
+			Formats:   strings.Split(*keypairFormat, ","),
+			Out:       *keypairOut,
+			Agent:     *keypairAgent,
+			Algorithm: *keypairAlgorithm,
+			KeyHash:   os.Stdout,
+		},
+	)
+}
+
+// KeygenInspect takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls keygen.Inspect after they are parsed.
+func KeygenInspect(args []string) error {
+	// Create a custom flag set and register flags
+	inspectCmd := flag.NewFlagSet("inspect", flag.ExitOnError)
+	inspectRoot := inspectCmd.String("root", "", "Root certificate(s) in PEM format. If set, certificates are"+
+		" checked to chain to them, through any intermediates in the same file.")
+	inspectJSON := inspectCmd.Bool("json", false, "Print the result as JSON.")
+	inspectLogLevel := inspectCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+	inspectCmd.Usage = func() {
+		fmt.Fprintf(inspectCmd.Output(), "Usage: stmgr keygen inspect [flags...] FILE...\n")
+		inspectCmd.PrintDefaults()
+	}
+
+	// Parse which flags are provided to the function
+	if err := inspectCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if inspectCmd.NArg() == 0 {
+		return errors.New("missing certificate or key file to inspect")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*inspectLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	inspectCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return keygen.Inspect(
+		&keygen.InspectArgs{
+			Files:     inspectCmd.Args(),
+			RootCerts: *inspectRoot,
+			JSON:      *inspectJSON,
+			Out:       os.Stdout,
 		},
 	)
 }
@@ -3,8 +3,14 @@ package eval
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/initramfs"
 	"system-transparency.org/stmgr/ospkg"
 )
 
@@ -23,8 +29,20 @@ func OspkgCreate(args []string) error {
 		" Defaults to 'System Transparency OS package <kernel>'.")
 	createURL := createCmd.String("url", "", "URL of the OS package zip file in case of network boot mode.")
 	createKernel := createCmd.String("kernel", "", "Operating system kernel.")
-	createInitramfs := createCmd.String("initramfs", "", "Operating system initramfs.")
+	var createInitramfs stringList
+	createCmd.Var(&createInitramfs, "initramfs", "Operating system initramfs."+
+		" Can be repeated, the files are then concatenated in order, e.g., microcode first.")
+	createInitramfsDir := createCmd.String("initramfs-dir", "", "Directory to write as a newc cpio initramfs,"+
+		" appended after any -initramfs files. Owners, timestamps and entry order are normalised.")
+	createInitramfsCompression := createCmd.String("initramfs-compression", initramfs.CompressGzip,
+		"Compression of the -initramfs-dir archive, any of none, gzip, xz and zstd.")
 	createCmdLine := createCmd.String("cmdline", "", "Kernel command line.")
+	createFromUKI := createCmd.String("from-uki", "", "Unified kernel image to take kernel, initramfs and cmdline from."+
+		" Cannot be combined with -kernel, -initramfs, -initramfs-dir and -cmdline.")
+	createReproducible := createCmd.Bool("reproducible", false, "Normalise timestamps, permissions and order of archive entries."+
+		" Implied if SOURCE_DATE_EPOCH is set, which is then used as timestamp.")
+	createNoCheck := createCmd.Bool("no-check", false, "Do not check that the kernel is a Linux kernel image,"+
+		" and that the cmdline has no duplicate parameters, conflicting consoles or unbalanced quotes.")
 	createLogLevel := createCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
 
 	// Parse which flags are provided to the function
@@ -44,15 +62,32 @@ func OspkgCreate(args []string) error {
 		stlog.Debug("Registered flag %q", f)
 	})
 
+	var modTime time.Time
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", epoch, err)
+		}
+		modTime = time.Unix(seconds, 0)
+		*createReproducible = true
+	}
+
 	// Call function with parsed flags
 	return ospkg.Create(
 		&ospkg.CreateArgs{
-			OutPath:   *createOut,
-			Label:     *createLabel,
-			URL:       *createURL,
-			Kernel:    *createKernel,
-			Initramfs: *createInitramfs,
-			Cmdline:   *createCmdLine,
+			OutPath:      *createOut,
+			Label:        *createLabel,
+			URL:          *createURL,
+			Kernel:       *createKernel,
+			Cmdline:      *createCmdLine,
+			Initramfs:    createInitramfs,
+			FromUKI:      *createFromUKI,
+			Reproducible: *createReproducible,
+			ModTime:      modTime,
+			NoCheck:      *createNoCheck,
+
+			InitramfsDir:         *createInitramfsDir,
+			InitramfsCompression: *createInitramfsCompression,
 		},
 	)
 }
@@ -63,8 +98,11 @@ func OspkgCreate(args []string) error {
 func OspkgSign(args []string) error {
 	// Create a custom flag set and register flags
 	signCmd := flag.NewFlagSet("sign", flag.ExitOnError)
-	signKey := signCmd.String("key", "", "Private key for signing.")
-	signCert := signCmd.String("cert", "", "Certificate corresponding to the private key.")
+	var signKeys, signCerts stringList
+	signCmd.Var(&signKeys, "key", "Private key for signing, or a pkcs11: URI or signer: spec, see the manual."+
+		" Can be repeated, together with -cert, to add several signatures at once.")
+	signCmd.Var(&signCerts, "cert", "Certificate corresponding to the private key."+
+		" The n:th -cert belongs to the n:th -key.")
 	signOSPKG := signCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
 	signLogLevel := signCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
 
@@ -86,7 +124,7 @@ func OspkgSign(args []string) error {
 	})
 
 	// Call function with parsed flags
-	return ospkg.Sign(*signKey, *signCert, *signOSPKG)
+	return ospkg.Sign(signKeys, signCerts, *signOSPKG)
 }
 
 func OspkgSigsum(args []string) error {
@@ -95,6 +133,14 @@ func OspkgSigsum(args []string) error {
 	sigsumProof := sigsumCmd.String("proof", "", "Sigsum proof of logging.")
 	cert := sigsumCmd.String("cert", "", "Certificate for the Sigsum submission key.")
 	sigsumOSPKG := sigsumCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+	sigsumSubmit := sigsumCmd.Bool("submit", false, "Submit the OS package to a Sigsum log, instead of using -proof.")
+	sigsumKey := sigsumCmd.String("key", "", "Sigsum submission key, for -submit.")
+	sigsumPolicy := sigsumCmd.String("policy", "", "Sigsum policy listing logs and witnesses."+
+		" Used to submit with -submit, and to verify the complete proof before attaching it.")
+	sigsumTrustPolicy := sigsumCmd.String("trustPolicy", "", "Trust policy directory, to use its Sigsum policy as with -policy.")
+	sigsumTimeout := sigsumCmd.Duration("timeout", ospkg.DefaultSigsumTimeout, "Timeout for the complete submission.")
+	sigsumLogTimeout := sigsumCmd.Duration("logTimeout", ospkg.DefaultSigsumLogTimeout,
+		"Timeout for each log, before trying the next log in the policy.")
 	sigsumLogLevel := sigsumCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
 
 	// Parse which flags are provided to the function
@@ -114,8 +160,112 @@ func OspkgSigsum(args []string) error {
 		stlog.Debug("Registered flag %q", f)
 	})
 
+	if *sigsumTrustPolicy != "" {
+		if *sigsumPolicy != "" {
+			return errors.New("the policy and trustPolicy flags are mutually exclusive")
+		}
+		*sigsumPolicy = ospkg.SigsumPolicyPath(*sigsumTrustPolicy)
+	}
+
+	if !*sigsumSubmit {
+		if *sigsumKey != "" {
+			return errors.New("the key flag requires -submit")
+		}
+		// Call function with parsed flags
+		return ospkg.AddSigsumProof(*sigsumProof, *cert, *sigsumOSPKG, *sigsumPolicy)
+	}
+
+	if *sigsumProof != "" {
+		return errors.New("the proof and submit flags are mutually exclusive")
+	}
+	if *sigsumKey == "" || *sigsumPolicy == "" {
+		return errors.New("submit requires -key, and -policy or -trustPolicy")
+	}
+
+	// Call function with parsed flags
+	return ospkg.SubmitSigsum(&ospkg.SubmitArgs{
+		KeyPath:    *sigsumKey,
+		CertPath:   *cert,
+		PolicyPath: *sigsumPolicy,
+		PkgPath:    *sigsumOSPKG,
+		Timeout:    *sigsumTimeout,
+		LogTimeout: *sigsumLogTimeout,
+	})
+}
+
+// OspkgSignRequest takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls ospkg.CreateSignRequest after they are parsed.
+func OspkgSignRequest(args []string) error {
+	// Create a custom flag set and register flags
+	requestCmd := flag.NewFlagSet("sign-request", flag.ExitOnError)
+	requestCert := requestCmd.String("cert", "", "Certificate corresponding to the private key that will sign.")
+	requestOSPKG := requestCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+	requestOut := requestCmd.String("out", "", "Sign request output file."+
+		" Defaults to the OS package name with extension .sign-request.json.")
+	requestLogLevel := requestCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := requestCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if requestCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument, use the ospkg flag to set the OS package to sign")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*requestLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	requestCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return ospkg.CreateSignRequest(*requestCert, *requestOSPKG, *requestOut)
+}
+
+// OspkgAttachSignature takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls ospkg.AttachSignature after they are parsed.
+func OspkgAttachSignature(args []string) error {
+	// Create a custom flag set and register flags
+	attachCmd := flag.NewFlagSet("attach-signature", flag.ExitOnError)
+	attachSignature := attachCmd.String("signature", "", "File with a raw Ed25519 signature of the archive hash.")
+	attachRequest := attachCmd.String("request", "", "Sign request the signature was made for.")
+	attachCert := attachCmd.String("cert", "", "Certificate of the signing key, if no sign request is used.")
+	attachOSPKG := attachCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+	attachLogLevel := attachCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := attachCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if attachCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument, use the ospkg flag to set the OS package to attach the signature to")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*attachLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	attachCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	if *attachSignature == "" {
+		return errors.New("missing signature file")
+	}
+
 	// Call function with parsed flags
-	return ospkg.AddSigsumProof(*sigsumProof, *cert, *sigsumOSPKG)
+	return ospkg.AttachSignature(&ospkg.AttachArgs{
+		PkgPath:       *attachOSPKG,
+		SignaturePath: *attachSignature,
+		RequestPath:   *attachRequest,
+		CertPath:      *attachCert,
+	})
 }
 
 func OspkgVerify(args []string) error {
@@ -124,6 +274,18 @@ func OspkgVerify(args []string) error {
 	verifyTrustPolicyDir := verifyCmd.String("trustPolicy", "", "Trust policy directory to use for verifying.")
 	verifyRootCerts := verifyCmd.String("rootCerts", "", "File with root certificate(s) to use for verifying.")
 	verifyOSPKG := verifyCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+	verifyReport := verifyCmd.String("report", "", "Print a per-signature verification report, in text or json format.")
+	verifyAt := verifyCmd.String("at", "", "Date formatted as RFC3339, to verify as of that point in time."+
+		" Defaults to the current time.")
+	verifyDir := verifyCmd.String("dir", "", "Directory to search for OS packages, to verify all of them."+
+		" Cannot be combined with -ospkg.")
+	verifyWorkers := verifyCmd.Int("workers", 0, "Number of OS packages to verify in parallel with -dir."+
+		" Defaults to the number of CPUs.")
+	verifyHostConfig := verifyCmd.String("hostconfig", "", "Host configuration file, to check that its OS package pointer"+
+		" points at the OS package. Requires -trustPolicy.")
+	verifyExpiry := verifyCmd.Duration("expiry", ospkg.DefaultExpiry, "With -dir, report OS packages that stop"+
+		" being verifiable within this duration.")
+	verifyReportFile := verifyCmd.String("reportFile", "", "With -dir, also write the report in json format to this file.")
 	verifyLogLevel := verifyCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
 
 	// Parse which flags are provided to the function
@@ -151,9 +313,268 @@ func OspkgVerify(args []string) error {
 		return errors.New("one of the flags trustPolicy and rootCerts must be used")
 	}
 
+	if *verifyDir != "" && *verifyOSPKG != "" {
+		return errors.New("the dir and ospkg flags cannot be used together")
+	}
+
+	if *verifyHostConfig != "" && (*verifyTrustPolicyDir == "" || *verifyDir != "") {
+		return errors.New("the hostconfig flag requires trustPolicy and ospkg")
+	}
+
+	if *verifyReportFile != "" && *verifyDir == "" {
+		return errors.New("the reportFile flag requires dir")
+	}
+
+	switch *verifyReport {
+	case "", ospkg.ReportText, ospkg.ReportJSON:
+	default:
+		return fmt.Errorf("invalid report format %q, must be text or json", *verifyReport)
+	}
+
+	at, err := parseDate(*verifyAt, time.Time{})
+	if err != nil {
+		return fmt.Errorf("invalid at date %q: %w", *verifyAt, err)
+	}
+
+	verifyArgs := &ospkg.VerifyArgs{
+		PkgPath: *verifyOSPKG,
+		At:      at,
+		Report:  *verifyReport,
+		Out:     os.Stdout,
+		Dir:     *verifyDir,
+		Workers: *verifyWorkers,
+		Expiry:  *verifyExpiry,
+
+		ReportFile: *verifyReportFile,
+
+		HostConfigPath: *verifyHostConfig,
+	}
+
 	if *verifyTrustPolicyDir != "" {
-		return ospkg.VerifyTrustPolicy(*verifyTrustPolicyDir, *verifyOSPKG)
+		return ospkg.VerifyTrustPolicy(*verifyTrustPolicyDir, verifyArgs)
 	} else {
-		return ospkg.VerifyRootCerts(*verifyRootCerts, *verifyOSPKG)
+		return ospkg.VerifyRootCerts(*verifyRootCerts, verifyArgs)
+	}
+}
+
+// OspkgInspect takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls ospkg.Inspect after they are parsed.
+func OspkgInspect(args []string) error {
+	// Create a custom flag set and register flags
+	inspectCmd := flag.NewFlagSet("inspect", flag.ExitOnError)
+	inspectOSPKG := inspectCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+	inspectJSON := inspectCmd.Bool("json", false, "Print the result as JSON.")
+	inspectLogLevel := inspectCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := inspectCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if inspectCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument, use the ospkg flag to set the OS package to inspect")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*inspectLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	inspectCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return ospkg.Inspect(*inspectOSPKG, *inspectJSON, os.Stdout)
+}
+
+// OspkgExtract takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls ospkg.Extract after they are parsed.
+func OspkgExtract(args []string) error {
+	// Create a custom flag set and register flags
+	extractCmd := flag.NewFlagSet("extract", flag.ExitOnError)
+	extractOSPKG := extractCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+	extractOut := extractCmd.String("out", "", "Output directory for the kernel, initramfs and cmdline files.")
+	extractTrustPolicyDir := extractCmd.String("trustPolicy", "", "Trust policy directory. If set, the OS package"+
+		" is only extracted if it can be verified.")
+	extractLogLevel := extractCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := extractCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if extractCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument, use the ospkg flag to set the OS package to extract")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*extractLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	extractCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	if *extractOut == "" {
+		return errors.New("missing required flag: -out")
+	}
+
+	// Call function with parsed flags
+	return ospkg.Extract(
+		&ospkg.ExtractArgs{
+			PkgPath:        *extractOSPKG,
+			OutDir:         *extractOut,
+			TrustPolicyDir: *extractTrustPolicyDir,
+		},
+	)
+}
+
+// OspkgDiff takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls ospkg.Diff after they are parsed.
+func OspkgDiff(args []string) error {
+	// Create a custom flag set and register flags
+	diffCmd := flag.NewFlagSet("diff", flag.ExitOnError)
+	diffJSON := diffCmd.Bool("json", false, "Print the differences as JSON.")
+	diffLogLevel := diffCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := diffCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if diffCmd.NArg() != 2 {
+		return errors.New("expected two positional arguments, the old and the new OS package")
+	}
+
+	// Flag parsing stops at the first positional argument, so
+	// don't silently ignore flags after them.
+	for _, arg := range diffCmd.Args() {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("flag %q must come before the OS packages", arg)
+		}
+	}
+
+	// Adjust loglevel
+	setLoglevel(*diffLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	diffCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return ospkg.Diff(diffCmd.Arg(0), diffCmd.Arg(1), *diffJSON, os.Stdout)
+}
+
+// OspkgSignaturesList takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls ospkg.ListSignatures after they are parsed.
+func OspkgSignaturesList(args []string) error {
+	// Create a custom flag set and register flags
+	listCmd := flag.NewFlagSet("signatures list", flag.ExitOnError)
+	listOSPKG := listCmd.String("ospkg", "", "OS package descriptor file.")
+	listJSON := listCmd.Bool("json", false, "Output in JSON format.")
+	listLogLevel := listCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := listCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if listCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument, use the ospkg flag to set the OS package")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*listLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	listCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return ospkg.ListSignatures(*listOSPKG, *listJSON, os.Stdout)
+}
+
+// OspkgSignaturesRemove takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls ospkg.RemoveSignatures after they are parsed.
+func OspkgSignaturesRemove(args []string) error {
+	// Create a custom flag set and register flags
+	removeCmd := flag.NewFlagSet("signatures remove", flag.ExitOnError)
+	removeOSPKG := removeCmd.String("ospkg", "", "OS package descriptor file.")
+	removeIndex := removeCmd.Int("index", -1, "Index of the signature to remove, as shown by signatures list.")
+	removeKeyHash := removeCmd.String("keyhash", "", "Remove all signatures by the key with this hash, as shown by signatures list.")
+	removeLogLevel := removeCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := removeCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if removeCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument, use the ospkg flag to set the OS package")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*removeLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	removeCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return ospkg.RemoveSignatures(&ospkg.RemoveArgs{
+		PkgPath: *removeOSPKG,
+		Index:   *removeIndex,
+		KeyHash: *removeKeyHash,
+	})
+}
+
+// OspkgSignaturesPrune takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls ospkg.PruneSignatures after they are parsed.
+func OspkgSignaturesPrune(args []string) error {
+	// Create a custom flag set and register flags
+	pruneCmd := flag.NewFlagSet("signatures prune", flag.ExitOnError)
+	pruneOSPKG := pruneCmd.String("ospkg", "", "OS package descriptor file.")
+	pruneRootCerts := pruneCmd.String("rootCerts", "", "File with root certificate(s)."+
+		" If set, signatures with certificates not chaining to these are removed as well.")
+	pruneAt := pruneCmd.String("at", "", "Date formatted as RFC3339, to prune as of that point in time."+
+		" Defaults to the current time.")
+	pruneLogLevel := pruneCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := pruneCmd.Parse(args); err != nil {
+		return err
 	}
+
+	if pruneCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument, use the ospkg flag to set the OS package")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*pruneLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	pruneCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	at, err := parseDate(*pruneAt, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	// Call function with parsed flags
+	return ospkg.PruneSignatures(&ospkg.PruneArgs{
+		PkgPath:       *pruneOSPKG,
+		RootCertsPath: *pruneRootCerts,
+		At:            at,
+	})
 }
@@ -0,0 +1,211 @@
+package eval
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/secureboot"
+)
+
+// Firmware doesn't check validity periods, but other tools may, so
+// Secure Boot certificates are long-lived by default.
+const (
+	defaultSecureBootValidDuration = 10 * 365 * 24 * time.Hour
+)
+
+// SecurebootKeys takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls secureboot.Keys after they are parsed.
+func SecurebootKeys(args []string) error {
+	// Create a custom flag set and register flags
+	keysCmd := flag.NewFlagSet("keys", flag.ExitOnError)
+	keysDir := keysCmd.String("dir", "", "Output directory for the PK, KEK and db certificates, keys,"+
+		" signature lists and .auth files.")
+	keysAlgorithm := keysCmd.String("algorithm", "rsa2048", "Key algorithm, any of rsa2048, rsa3072 and rsa4096.")
+	keysOwner := keysCmd.String("owner", "", "Owner GUID of the signature list entries. Defaults to a random GUID.")
+	keysValidFrom := keysCmd.String("validFrom", "", "Date formatted as RFC3339."+
+		" Defaults to time of creation.")
+	keysValidUntil := keysCmd.String("validUntil", "", "Date formatted as RFC3339."+
+		" Defaults to time of creation + 10 years.")
+	keysLogLevel := keysCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := keysCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if keysCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*keysLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	keysCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	now := time.Now()
+
+	notBefore, err := parseDate(*keysValidFrom, now)
+	if err != nil {
+		return fmt.Errorf("invalid validFrom date %q: %w", *keysValidFrom, err)
+	}
+
+	notAfter, err := parseDate(*keysValidUntil, now.Add(defaultSecureBootValidDuration))
+	if err != nil {
+		return fmt.Errorf("invalid validUntil date %q: %w", *keysValidUntil, err)
+	}
+
+	// Call function with parsed flags
+	return secureboot.Keys(
+		&secureboot.KeysArgs{
+			Dir:       *keysDir,
+			Algorithm: *keysAlgorithm,
+			Owner:     *keysOwner,
+			NotBefore: notBefore,
+			NotAfter:  notAfter,
+		},
+	)
+}
+
+// SecurebootESL takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls secureboot.ESL after they are parsed.
+func SecurebootESL(args []string) error {
+	// Create a custom flag set and register flags
+	eslCmd := flag.NewFlagSet("esl", flag.ExitOnError)
+	var eslCerts, eslHashes stringList
+	eslCmd.Var(&eslCerts, "cert", "Certificate file in PEM format, to list the certificates in it."+
+		" Can be repeated.")
+	eslCmd.Var(&eslHashes, "hash", "PE file, e.g., a UKI, to list its SHA-256 Authenticode hash,"+
+		" e.g., to revoke it in dbx. Can be repeated.")
+	eslOwner := eslCmd.String("owner", "", "Owner GUID of the entries, e.g., from the owner.guid file"+
+		" written by secureboot keys. Defaults to a random GUID.")
+	eslOut := eslCmd.String("out", "", "Output signature list file.")
+	eslLogLevel := eslCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := eslCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if eslCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*eslLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	eslCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return secureboot.ESL(
+		&secureboot.ESLArgs{
+			Certs:  eslCerts,
+			Hashes: eslHashes,
+			Owner:  *eslOwner,
+			Out:    *eslOut,
+		},
+	)
+}
+
+// SecurebootAuth takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls secureboot.Auth after they are parsed.
+func SecurebootAuth(args []string) error {
+	// Create a custom flag set and register flags
+	authCmd := flag.NewFlagSet("auth", flag.ExitOnError)
+	authVariable := authCmd.String("var", "", "Variable to update, any of PK, KEK, db and dbx.")
+	authESL := authCmd.String("esl", "", "Signature list to write, as written by secureboot esl,"+
+		" or PEM certificates. If not set, the variable is cleared, e.g., to leave user mode by removing PK.")
+	authSignKey := authCmd.String("signkey", "", "Private key of PK (for PK and KEK) or of a KEK (for db and dbx),"+
+		" in PEM format, or a pkcs11: URI or signer: spec, see the manual.")
+	authSignCert := authCmd.String("signcert", "", "Certificate corresponding to the private key (a file in PEM format).")
+	authAppend := authCmd.Bool("append", false, "Append to the variable, rather than replacing it.")
+	authTime := authCmd.String("time", "", "Timestamp formatted as RFC3339, must be later than that of"+
+		" the previous update. Defaults to now.")
+	authOut := authCmd.String("out", "", "Output .auth file.")
+	authLogLevel := authCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := authCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if authCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*authLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	authCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	timestamp, err := parseDate(*authTime, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid time %q: %w", *authTime, err)
+	}
+
+	// Call function with parsed flags
+	return secureboot.Auth(
+		&secureboot.AuthArgs{
+			Variable: *authVariable,
+			ESL:      *authESL,
+			SignKey:  *authSignKey,
+			SignCert: *authSignCert,
+			Append:   *authAppend,
+			Time:     timestamp,
+			Out:      *authOut,
+		},
+	)
+}
+
+// SecurebootCheck takes arguments like os.Args as a string array
+// and maps them to their corresponding flags using the std flag
+// package. It then calls secureboot.Check after they are parsed.
+func SecurebootCheck(args []string) error {
+	// Create a custom flag set and register flags
+	checkCmd := flag.NewFlagSet("check", flag.ExitOnError)
+	checkUKI := checkCmd.String("uki", "", "UKI, or other PE file, to check.")
+	var checkDb, checkDbx stringList
+	checkCmd.Var(&checkDb, "db", "db contents: a signature list, .auth file, or PEM certificates. Can be repeated.")
+	checkCmd.Var(&checkDbx, "dbx", "dbx contents, in the same formats as -db. Can be repeated.")
+	checkLogLevel := checkCmd.String("loglevel", "", "Set loglevel to any of debug, info (default), warn, error and panic.")
+
+	// Parse which flags are provided to the function
+	if err := checkCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if checkCmd.NArg() > 0 {
+		return errors.New("unexpected positional argument")
+	}
+
+	// Adjust loglevel
+	setLoglevel(*checkLogLevel)
+
+	// Print the successfully parsed flags in debug level
+	checkCmd.Visit(func(f *flag.Flag) {
+		stlog.Debug("Registered flag %q", f)
+	})
+
+	// Call function with parsed flags
+	return secureboot.Check(
+		&secureboot.CheckArgs{
+			UKI: *checkUKI,
+			Db:  checkDb,
+			Dbx: checkDbx,
+		},
+	)
+}
@@ -0,0 +1,75 @@
+// Package initramfs reads and writes initramfs images, i.e., newc
+// format cpio archives, optionally compressed and concatenated.
+package initramfs
+
+import "io/fs"
+
+// Format of the newc cpio header, see
+// https://www.kernel.org/doc/html/latest/driver-api/early-userspace/buffer-format.html
+const (
+	magicNewc    = "070701"
+	magicNewcCRC = "070702"
+	headerSize   = 110
+	fieldCount   = 13
+	trailerName  = "TRAILER!!!"
+
+	modeTypeMask = 0o170000
+	modeSocket   = 0o140000
+	modeSymlink  = 0o120000
+	modeRegular  = 0o100000
+	modeBlock    = 0o060000
+	modeDir      = 0o040000
+	modeChar     = 0o020000
+	modeFIFO     = 0o010000
+)
+
+// Header is a cpio entry header.
+type Header struct {
+	Name      string
+	Ino       uint32
+	Mode      uint32 // Unix file type and permission bits.
+	UID       uint32
+	GID       uint32
+	NLink     uint32
+	MTime     uint32
+	Size      uint32
+	DevMajor  uint32
+	DevMinor  uint32
+	RDevMajor uint32
+	RDevMinor uint32
+}
+
+// FileMode converts the Unix mode of the header to an fs.FileMode.
+func (h *Header) FileMode() fs.FileMode {
+	mode := fs.FileMode(h.Mode & 0o777)
+	if h.Mode&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if h.Mode&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if h.Mode&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	switch h.Mode & modeTypeMask {
+	case modeSocket:
+		mode |= fs.ModeSocket
+	case modeSymlink:
+		mode |= fs.ModeSymlink
+	case modeBlock:
+		mode |= fs.ModeDevice
+	case modeDir:
+		mode |= fs.ModeDir
+	case modeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case modeFIFO:
+		mode |= fs.ModeNamedPipe
+	}
+
+	return mode
+}
+
+func align4(n int64) int64 {
+	return (4 - n%4) % 4
+}
@@ -0,0 +1,191 @@
+package initramfs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicXz    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicBzip2 = []byte("BZh")
+	magicLz4   = []byte{0x02, 0x21, 0x4c, 0x18}
+)
+
+var ErrFormat = errors.New("invalid initramfs")
+
+// WalkFunc is called by Walk for every entry. For regular files,
+// contents reads the file data, and for symlinks the link target.
+type WalkFunc func(hdr *Header, contents io.Reader) error
+
+// Walk calls fn for each entry of an initramfs. Like the kernel, it
+// accepts several cpio archives after each other, each either
+// uncompressed or compressed with gzip, xz or zstd, separated by zero
+// padding. Archives compressed with xz or zstd must be the last ones.
+func Walk(r io.Reader, fn WalkFunc) error {
+	br := bufio.NewReader(r)
+
+	for {
+		magic, err := br.Peek(len(magicXz))
+		if len(magic) == 0 {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		switch {
+		case magic[0] == 0:
+			// Padding between archives.
+			if _, err := br.ReadByte(); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(magic, []byte(magicNewc)), bytes.HasPrefix(magic, []byte(magicNewcCRC)):
+			if err := readArchive(br, fn); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(magic, magicGzip):
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				return err
+			}
+			// Stop at the end of this gzip member, the next
+			// archive need not be gzip compressed.
+			zr.Multistream(false)
+			if err := Walk(zr, fn); err != nil {
+				return err
+			}
+			if err := zr.Close(); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(magic, magicXz):
+			zr, err := xz.NewReader(br)
+			if err != nil {
+				return err
+			}
+			return Walk(zr, fn)
+		case bytes.HasPrefix(magic, magicZstd):
+			zr, err := zstd.NewReader(br)
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+			return Walk(zr, fn)
+		case bytes.HasPrefix(magic, magicBzip2), bytes.HasPrefix(magic, magicLz4):
+			return fmt.Errorf("%w: unsupported compression", ErrFormat)
+		default:
+			return fmt.Errorf("%w: unknown format, magic %x", ErrFormat, magic)
+		}
+	}
+}
+
+// readArchive reads one cpio archive, up to and including its
+// trailer entry.
+func readArchive(r io.Reader, fn WalkFunc) error {
+	cr := &countingReader{r: r}
+
+	for {
+		hdr, err := readHeader(cr)
+		if err != nil {
+			return err
+		}
+		if hdr.Name == trailerName {
+			return nil
+		}
+
+		start := cr.n
+		contents := io.LimitReader(cr, int64(hdr.Size))
+		if err := fn(hdr, contents); err != nil {
+			return err
+		}
+		// Skip whatever fn didn't read.
+		if _, err := io.Copy(io.Discard, contents); err != nil {
+			return err
+		}
+		if cr.n-start != int64(hdr.Size) {
+			return fmt.Errorf("%w: truncated contents of %q", ErrFormat, hdr.Name)
+		}
+		if err := cr.skip(align4(cr.n)); err != nil {
+			return err
+		}
+	}
+}
+
+func readHeader(cr *countingReader) (*Header, error) {
+	var buf [headerSize]byte
+	if _, err := io.ReadFull(cr, buf[:]); err != nil {
+		return nil, fmt.Errorf("%w: reading header: %v", ErrFormat, err)
+	}
+	if magic := string(buf[:len(magicNewc)]); magic != magicNewc && magic != magicNewcCRC {
+		return nil, fmt.Errorf("%w: bad cpio magic %q", ErrFormat, magic)
+	}
+
+	var fields [fieldCount]uint32
+	for i := range fields {
+		start := len(magicNewc) + 8*i
+		v, err := strconv.ParseUint(string(buf[start:start+8]), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad cpio header field: %v", ErrFormat, err)
+		}
+		fields[i] = uint32(v)
+	}
+
+	namesize := int64(fields[11])
+	if namesize == 0 {
+		return nil, fmt.Errorf("%w: empty cpio entry name", ErrFormat)
+	}
+	name := make([]byte, namesize)
+	if _, err := io.ReadFull(cr, name); err != nil {
+		return nil, fmt.Errorf("%w: reading name: %v", ErrFormat, err)
+	}
+	if err := cr.skip(align4(cr.n)); err != nil {
+		return nil, err
+	}
+
+	return &Header{
+		Name:      strings.TrimRight(string(name), "\x00"),
+		Ino:       fields[0],
+		Mode:      fields[1],
+		UID:       fields[2],
+		GID:       fields[3],
+		NLink:     fields[4],
+		MTime:     fields[5],
+		Size:      fields[6],
+		DevMajor:  fields[7],
+		DevMinor:  fields[8],
+		RDevMajor: fields[9],
+		RDevMinor: fields[10],
+	}, nil
+}
+
+// countingReader keeps track of the offset, for the 4 byte alignment
+// within cpio archives.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+func (cr *countingReader) skip(n int64) error {
+	if _, err := io.CopyN(io.Discard, cr, n); err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package initramfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type testEntry struct {
+	name     string
+	mode     uint32
+	contents string
+}
+
+// newcArchive builds a cpio archive by hand, independent of Writer.
+func newcArchive(entries []testEntry) []byte {
+	buf := new(bytes.Buffer)
+	write := func(name string, mode uint32, contents string) {
+		fmt.Fprintf(buf, "%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			magicNewc, 0, mode, 0, 0, 1, 0, len(contents), 0, 0, 0, 0, len(name)+1, 0)
+		buf.WriteString(name + "\x00")
+		buf.Write(make([]byte, align4(int64(buf.Len()))))
+		buf.WriteString(contents)
+		buf.Write(make([]byte, align4(int64(buf.Len()))))
+	}
+	for _, e := range entries {
+		write(e.name, e.mode, e.contents)
+	}
+	write(trailerName, 0, "")
+	// Pad to 512 bytes, like cpio does.
+	buf.Write(make([]byte, (512-buf.Len()%512)%512))
+
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func walkAll(data []byte) ([]testEntry, error) {
+	var got []testEntry
+	err := Walk(bytes.NewReader(data), func(hdr *Header, contents io.Reader) error {
+		b, err := io.ReadAll(contents)
+		if err != nil {
+			return err
+		}
+		got = append(got, testEntry{name: hdr.Name, mode: hdr.Mode, contents: string(b)})
+
+		return nil
+	})
+
+	return got, err
+}
+
+func TestWalk(t *testing.T) {
+	first := []testEntry{
+		{name: "kernel", mode: modeDir | 0o755},
+		{name: "kernel/microcode.bin", mode: modeRegular | 0o644, contents: "ucode"},
+	}
+	second := []testEntry{
+		{name: "init", mode: modeRegular | 0o755, contents: "#!/bin/sh\n"},
+		{name: "bin/sh", mode: modeSymlink | 0o777, contents: "busybox"},
+	}
+
+	for _, table := range []struct {
+		name string
+		data []byte
+		want []testEntry
+	}{
+		{
+			name: "plain",
+			data: newcArchive(second),
+			want: second,
+		},
+		{
+			name: "gzip",
+			data: gzipped(t, newcArchive(second)),
+			want: second,
+		},
+		{
+			name: "plain then gzip",
+			data: append(newcArchive(first), gzipped(t, newcArchive(second))...),
+			want: append(append([]testEntry{}, first...), second...),
+		},
+	} {
+		t.Run(table.name, func(t *testing.T) {
+			got, err := walkAll(table.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, table.want) {
+				t.Errorf("got %+v, want %+v", got, table.want)
+			}
+		})
+	}
+}
+
+func TestWalkInvalid(t *testing.T) {
+	if _, err := walkAll([]byte("A dummy initramfs")); !errors.Is(err, ErrFormat) {
+		t.Errorf("got err %v, want %v", err, ErrFormat)
+	}
+	truncated := newcArchive([]testEntry{{name: "init", mode: modeRegular | 0o755, contents: "data"}})[:120]
+	if _, err := walkAll(truncated); !errors.Is(err, ErrFormat) {
+		t.Errorf("got err %v, want %v", err, ErrFormat)
+	}
+
+	// A gzip member with a corrupt deflate stream, and a truncated one.
+	compressed := gzipped(t, newcArchive([]testEntry{{name: "init", mode: modeRegular | 0o755, contents: "data"}}))
+	corrupt := append([]byte{}, compressed...)
+	corrupt[10] = 0xff
+	for _, data := range [][]byte{corrupt, compressed[:20]} {
+		if _, err := walkAll(data); err == nil {
+			t.Errorf("expected error for corrupt gzip member")
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package initramfs
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats for written initramfs images.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressXz   = "xz"
+	CompressZstd = "zstd"
+)
+
+var ErrCompression = errors.New("unsupported initramfs compression")
+
+// Writer writes a newc cpio archive. Each entry is written with
+// WriteHeader, followed by Size bytes of contents for regular files,
+// or the link target for symlinks.
+type Writer struct {
+	w         io.Writer
+	n         int64 // Bytes written, for alignment.
+	remaining int64 // Contents not yet written for the current entry.
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteHeader finishes the current entry, and starts a new one.
+func (w *Writer) WriteHeader(hdr *Header) error {
+	if err := w.finishEntry(); err != nil {
+		return err
+	}
+
+	fields := [fieldCount]uint32{
+		hdr.Ino, hdr.Mode, hdr.UID, hdr.GID, hdr.NLink, hdr.MTime, hdr.Size,
+		hdr.DevMajor, hdr.DevMinor, hdr.RDevMajor, hdr.RDevMinor,
+		uint32(len(hdr.Name) + 1), 0,
+	}
+	if _, err := w.write([]byte(magicNewc)); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if _, err := w.write([]byte(fmt.Sprintf("%08x", field))); err != nil {
+			return err
+		}
+	}
+	if _, err := w.write([]byte(hdr.Name + "\x00")); err != nil {
+		return err
+	}
+	if err := w.pad(); err != nil {
+		return err
+	}
+	w.remaining = int64(hdr.Size)
+
+	return nil
+}
+
+// Write writes contents of the current entry.
+func (w *Writer) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		return 0, fmt.Errorf("write of %d bytes exceeds entry size, %d bytes remaining", len(p), w.remaining)
+	}
+	n, err := w.write(p)
+	w.remaining -= int64(n)
+
+	return n, err
+}
+
+// Close writes the trailer, padded to 512 bytes like cpio does. It
+// does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.WriteHeader(&Header{Name: trailerName, NLink: 1}); err != nil {
+		return err
+	}
+	_, err := w.write(make([]byte, (512-w.n%512)%512))
+
+	return err
+}
+
+func (w *Writer) finishEntry() error {
+	if w.remaining != 0 {
+		return fmt.Errorf("entry contents short by %d bytes", w.remaining)
+	}
+
+	return w.pad()
+}
+
+func (w *Writer) pad() error {
+	_, err := w.write(make([]byte, align4(w.n)))
+
+	return err
+}
+
+func (w *Writer) write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+
+	return n, err
+}
+
+// WriteDir writes the directory tree at dir to w as a newc cpio
+// archive, compressed as given by compression. The archive only
+// depends on the names, types, permissions and contents of the files:
+// entries are sorted by path, owners are set to root, all timestamps
+// to modTime, and inode numbers are assigned in order. Hard links are
+// stored as separate files. Device nodes are not supported, since
+// building images should not need root.
+func WriteDir(w io.Writer, dir string, compression string, modTime time.Time) error {
+	cw, err := compressor(w, compression)
+	if err != nil {
+		return err
+	}
+
+	var mtime uint32
+	if modTime.Unix() > 0 {
+		mtime = uint32(modTime.Unix())
+	}
+
+	var paths []string
+	err = filepath.WalkDir(dir, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			paths = append(paths, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Sort by path, the order WalkDir uses within each directory
+	// does not matter to the kernel.
+	sort.Strings(paths)
+
+	cpio := NewWriter(cw)
+	for i, path := range paths {
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(cpio, path, filepath.ToSlash(name), uint32(i+1), mtime); err != nil {
+			return err
+		}
+	}
+	if err := cpio.Close(); err != nil {
+		return err
+	}
+
+	return cw.Close()
+}
+
+func writeFile(cpio *Writer, path, name string, ino, mtime uint32) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	hdr := &Header{
+		Name:  name,
+		Ino:   ino,
+		Mode:  uint32(info.Mode().Perm()),
+		NLink: 1,
+		MTime: mtime,
+	}
+	if info.Mode()&fs.ModeSetuid != 0 {
+		hdr.Mode |= 0o4000
+	}
+	if info.Mode()&fs.ModeSetgid != 0 {
+		hdr.Mode |= 0o2000
+	}
+	if info.Mode()&fs.ModeSticky != 0 {
+		hdr.Mode |= 0o1000
+	}
+
+	switch info.Mode().Type() {
+	case 0:
+		if info.Size() > int64(^uint32(0)) {
+			return fmt.Errorf("%q: too large for cpio, %d bytes", path, info.Size())
+		}
+		hdr.Mode |= modeRegular
+		hdr.Size = uint32(info.Size())
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := cpio.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if n, err := io.Copy(cpio, f); err != nil {
+			return err
+		} else if n != info.Size() {
+			return fmt.Errorf("%q: size changed while reading", path)
+		}
+
+		return nil
+	case fs.ModeDir:
+		hdr.Mode |= modeDir
+		hdr.NLink = 2
+	case fs.ModeSymlink:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		hdr.Mode |= modeSymlink
+		hdr.Size = uint32(len(target))
+		if err := cpio.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.WriteString(cpio, target)
+
+		return err
+	case fs.ModeNamedPipe:
+		hdr.Mode |= modeFIFO
+	case fs.ModeSocket:
+		hdr.Mode |= modeSocket
+	default:
+		return fmt.Errorf("%q: unsupported file type %v", path, info.Mode().Type())
+	}
+
+	return cpio.WriteHeader(hdr)
+}
+
+func compressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressNone:
+		return nopCloser{w}, nil
+	case CompressGzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case CompressXz:
+		// The kernel's xz decoder only supports CRC32 checks.
+		return xz.WriterConfig{CheckSum: xz.CRC32}.NewWriter(w)
+	case CompressZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("%w %q, must be one of %s, %s, %s and %s",
+			ErrCompression, compression, CompressNone, CompressGzip, CompressXz, CompressZstd)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
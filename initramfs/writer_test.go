@@ -0,0 +1,109 @@
+package initramfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	entries := []testEntry{
+		{name: "bin", mode: modeDir | 0o755},
+		{name: "bin/sh", mode: modeSymlink | 0o777, contents: "busybox"},
+		{name: "init", mode: modeRegular | 0o755, contents: "#!/bin/sh\n"},
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, e := range entries {
+		if err := w.WriteHeader(&Header{Name: e.name, Mode: e.mode, NLink: 1, Size: uint32(len(e.contents))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := newcArchive(entries); !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got archive %q, want %q", buf.Bytes(), want)
+	}
+}
+
+func TestWriterShortEntry(t *testing.T) {
+	w := NewWriter(new(bytes.Buffer))
+	if err := w.WriteHeader(&Header{Name: "init", Mode: modeRegular | 0o755, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("too long")); err == nil {
+		t.Errorf("write beyond entry size succeeded")
+	}
+	if err := w.Close(); err == nil {
+		t.Errorf("close with missing contents succeeded")
+	}
+}
+
+func TestWriteDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"bin", "etc"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "init"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "etc/hostname"), []byte("st\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("busybox", filepath.Join(dir, "bin/sh")); err != nil {
+		t.Fatal(err)
+	}
+	want := []testEntry{
+		{name: "bin", mode: modeDir | 0o755},
+		{name: "bin/sh", mode: modeSymlink | 0o777, contents: "busybox"},
+		{name: "etc", mode: modeDir | 0o755},
+		{name: "etc/hostname", mode: modeRegular | 0o644, contents: "st\n"},
+		{name: "init", mode: modeRegular | 0o755, contents: "#!/bin/sh\n"},
+	}
+	modTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, compression := range []string{CompressNone, CompressGzip, CompressXz, CompressZstd} {
+		t.Run(compression, func(t *testing.T) {
+			build := func() []byte {
+				buf := new(bytes.Buffer)
+				if err := WriteDir(buf, dir, compression, modTime); err != nil {
+					t.Fatal(err)
+				}
+
+				return buf.Bytes()
+			}
+
+			first := build()
+			now := time.Now()
+			if err := os.Chtimes(filepath.Join(dir, "init"), now, now); err != nil {
+				t.Fatal(err)
+			}
+			if second := build(); !bytes.Equal(first, second) {
+				t.Errorf("archives differ")
+			}
+
+			got, err := walkAll(first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	if err := WriteDir(new(bytes.Buffer), dir, "bzip2", modTime); err == nil {
+		t.Errorf("unsupported compression accepted")
+	}
+}
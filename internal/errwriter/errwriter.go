@@ -0,0 +1,21 @@
+// Package errwriter helps writing longer text reports.
+package errwriter
+
+import (
+	"fmt"
+	"io"
+)
+
+// Writer keeps the first write error, so that longer text reports need
+// not check every single write.
+type Writer struct {
+	W   io.Writer
+	Err error
+}
+
+func (ew *Writer) Printf(format string, args ...interface{}) {
+	if ew.Err != nil {
+		return
+	}
+	_, ew.Err = fmt.Fprintf(ew.W, format, args...)
+}
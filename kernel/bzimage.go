@@ -0,0 +1,104 @@
+// Package kernel inspects Linux kernel images and command lines.
+package kernel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Offsets into the real-mode header of a bzImage, see
+// https://www.kernel.org/doc/html/latest/arch/x86/boot.html
+const (
+	offsetSetupSects    = 0x1f1
+	offsetBootFlag      = 0x1fe
+	offsetHeaderMagic   = 0x202
+	offsetProtocol      = 0x206
+	offsetKernelVersion = 0x20e
+	headerEnd           = 0x210
+	offsetXLoadFlags    = 0x236
+
+	bootFlag    = 0xaa55
+	headerMagic = "HdrS"
+
+	// The xloadflags field is present from boot protocol 2.12.
+	protocolXLoadFlags = 0x20c
+	xlfKernel64        = 1 << 0
+	xlfEFIHandover32   = 1 << 2
+	xlfEFIHandover64   = 1 << 3
+
+	// The kernel_version pointer is relative to the end of the
+	// boot sector.
+	kernelVersionBase = 0x200
+	// HeaderSize is enough data to parse the setup header and the
+	// version string, which is within the at most 64 setup sectors
+	// following the boot sector.
+	HeaderSize = 65 * 512
+)
+
+var ErrNotBzImage = errors.New("not a bzImage kernel")
+
+// Header holds the information from a bzImage setup header, or the
+// header of another kernel image format, see ParseImage.
+type Header struct {
+	Arch     string // Architecture, e.g., x86_64 or arm64.
+	Protocol uint16 // Boot protocol version, e.g., 0x20f for 2.15.
+	Version  string // Kernel version string, may be empty.
+
+	// EFIStub is set if the image is also a PE executable, so that
+	// UEFI firmware or a UKI stub can start it directly.
+	EFIStub bool
+	// EFIHandover is set if the kernel supports the x86 EFI
+	// handover protocol, used by older UKI stubs.
+	EFIHandover bool
+}
+
+// ParseHeader parses the setup header at the start of a bzImage. The
+// data must include the setup sectors, HeaderSize bytes suffice.
+func ParseHeader(data []byte) (*Header, error) {
+	if len(data) < headerEnd {
+		return nil, fmt.Errorf("%w: too short", ErrNotBzImage)
+	}
+	if binary.LittleEndian.Uint16(data[offsetBootFlag:]) != bootFlag {
+		return nil, fmt.Errorf("%w: missing boot flag", ErrNotBzImage)
+	}
+	if string(data[offsetHeaderMagic:offsetHeaderMagic+len(headerMagic)]) != headerMagic {
+		return nil, fmt.Errorf("%w: missing header magic", ErrNotBzImage)
+	}
+
+	h := &Header{
+		Arch:     "x86",
+		Protocol: binary.LittleEndian.Uint16(data[offsetProtocol:]),
+	}
+	if h.Protocol >= protocolXLoadFlags && len(data) >= offsetXLoadFlags+2 {
+		xLoadFlags := binary.LittleEndian.Uint16(data[offsetXLoadFlags:])
+		if xLoadFlags&xlfKernel64 != 0 {
+			h.Arch = "x86_64"
+		}
+		h.EFIHandover = xLoadFlags&(xlfEFIHandover32|xlfEFIHandover64) != 0
+	}
+
+	setupSects := int(data[offsetSetupSects])
+	if setupSects == 0 {
+		// As documented for the boot protocol.
+		setupSects = 4
+	}
+	setupEnd := (setupSects + 1) * 512
+	if setupEnd > len(data) {
+		setupEnd = len(data)
+	}
+
+	if ptr := int(binary.LittleEndian.Uint16(data[offsetKernelVersion:])); ptr != 0 {
+		start := ptr + kernelVersionBase
+		if start < setupEnd {
+			version := data[start:setupEnd]
+			if i := bytes.IndexByte(version, 0); i >= 0 {
+				version = version[:i]
+			}
+			h.Version = string(version)
+		}
+	}
+
+	return h, nil
+}
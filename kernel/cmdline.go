@@ -0,0 +1,131 @@
+package kernel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"system-transparency.org/stboot/stlog"
+)
+
+var (
+	ErrUnbalancedQuotes = errors.New("unbalanced quotes in kernel command line")
+	ErrCmdline          = errors.New("suspicious kernel command line")
+)
+
+// singleValueParams are parameters of which only one value takes
+// effect, so that giving them more than once is a mistake. Consoles
+// are checked separately.
+var singleValueParams = map[string]bool{
+	"init":          true,
+	"nfsroot":       true,
+	"rdinit":        true,
+	"resume":        true,
+	"resume_offset": true,
+	"root":          true,
+	"rootflags":     true,
+	"rootfstype":    true,
+}
+
+// repeatableParams are parameters that are meant to be given more than
+// once. Other repeated parameters are warned about.
+var repeatableParams = map[string]bool{
+	"hugepages":      true,
+	"hugepagesz":     true,
+	"ip":             true,
+	"memmap":         true,
+	"nameserver":     true,
+	"rd.driver.pre":  true,
+	"rd.luks.name":   true,
+	"rd.luks.uuid":   true,
+	"rd.lvm.lv":      true,
+	"rd.md.uuid":     true,
+	"systemd.setenv": true,
+}
+
+// SplitCmdline splits a kernel command line into parameters the way
+// the kernel does: on whitespace, except within double quotes. The
+// quotes are kept in the returned parameters.
+func SplitCmdline(cmdline string) ([]string, error) {
+	var (
+		params  []string
+		current strings.Builder
+		quoted  bool
+	)
+
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if current.Len() > 0 {
+				params = append(params, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		params = append(params, current.String())
+	}
+	if quoted {
+		return params, ErrUnbalancedQuotes
+	}
+
+	return params, nil
+}
+
+// CheckCmdline checks a kernel command line for unbalanced quotes,
+// parameters that take a single value but are given more than once,
+// and console= parameters that set up the same device differently.
+// Other parameters given more than once, including identical console=
+// parameters, are only warned about.
+// Parameters after "--" are passed to init, and are not checked.
+func CheckCmdline(cmdline string) error {
+	params, err := SplitCmdline(cmdline)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	seen := make(map[string]string)
+	consoles := make(map[string]string)
+	for _, param := range params {
+		if param == "--" {
+			break
+		}
+		key, value, _ := strings.Cut(param, "=")
+
+		if key == "console" {
+			device, options, _ := strings.Cut(value, ",")
+			if previous, ok := consoles[device]; ok {
+				if previous != options {
+					problems = append(problems, fmt.Sprintf("conflicting settings for console %s: %q and %q",
+						device, previous, options))
+				} else {
+					stlog.Warn("Kernel command line console %s given more than once: %q", device, param)
+				}
+			}
+			consoles[device] = options
+
+			continue
+		}
+
+		if previous, ok := seen[key]; ok {
+			if singleValueParams[key] {
+				problems = append(problems, fmt.Sprintf("%s given more than once: %q and %q", key, previous, param))
+			} else if previous == param || !repeatableParams[key] {
+				stlog.Warn("Kernel command line parameter %s given more than once: %q and %q", key, previous, param)
+			}
+		}
+		seen[key] = param
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrCmdline, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
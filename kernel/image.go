@@ -0,0 +1,133 @@
+package kernel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Offsets and magic values of kernel image formats other than the x86
+// bzImage, see https://www.kernel.org/doc/html/latest/arch/arm64/booting.html
+// and https://www.kernel.org/doc/html/latest/arch/riscv/boot-image-header.html
+const (
+	offsetImageMagic = 0x38
+	offsetZbootMagic = 4
+	offsetPEHeader   = 0x3c
+
+	arm64Magic   = "ARM\x64"
+	riscv64Magic = "RSC\x05"
+	zbootMagic   = "zimg"
+	dosMagic     = "MZ"
+	peMagic      = "PE\x00\x00"
+)
+
+var ErrNotKernel = errors.New("not a Linux kernel image")
+
+// peMachines maps PE machine types to architecture names.
+var peMachines = map[uint16]string{
+	0x014c: "x86",
+	0x8664: "x86_64",
+	0x01c2: "arm",
+	0xaa64: "arm64",
+	0x5064: "riscv64",
+}
+
+// ParseImage parses the header of a Linux kernel image: an x86
+// bzImage, an arm64 or riscv64 Image, or an EFI zboot image. Other
+// files, including other PE executables, are rejected with
+// ErrNotKernel. As for ParseHeader, HeaderSize bytes suffice.
+func ParseImage(data []byte) (*Header, error) {
+	h, err := ParseHeader(data)
+	if err != nil {
+		switch {
+		case hasMagic(data, offsetImageMagic, arm64Magic):
+			h = &Header{Arch: "arm64"}
+		case hasMagic(data, offsetImageMagic, riscv64Magic):
+			h = &Header{Arch: "riscv64"}
+		case hasMagic(data, 0, dosMagic) && hasMagic(data, offsetZbootMagic, zbootMagic):
+			h = &Header{}
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrNotKernel, err)
+		}
+	}
+
+	if arch, ok := parsePEMachine(data); ok {
+		h.EFIStub = true
+		if arch != "" {
+			h.Arch = arch
+		}
+	}
+
+	return h, nil
+}
+
+// parsePEMachine returns the architecture of a PE executable, and
+// whether data starts with a PE header at all.
+func parsePEMachine(data []byte) (string, bool) {
+	if !hasMagic(data, 0, dosMagic) || len(data) < offsetPEHeader+4 {
+		return "", false
+	}
+	offset := int(binary.LittleEndian.Uint32(data[offsetPEHeader:]))
+	if offset < 0 || !hasMagic(data, offset, peMagic) || len(data) < offset+len(peMagic)+2 {
+		return "", false
+	}
+
+	return peMachines[binary.LittleEndian.Uint16(data[offset+len(peMagic):])], true
+}
+
+func hasMagic(data []byte, offset int, magic string) bool {
+	return offset+len(magic) <= len(data) && bytes.Equal(data[offset:offset+len(magic)], []byte(magic))
+}
+
+// String describes the image format, e.g., "x86_64 bzImage, boot
+// protocol 2.15, EFI stub, EFI handover".
+func (h *Header) String() string {
+	arch := h.Arch
+	if arch == "" {
+		arch = "unknown architecture"
+	}
+	parts := []string{arch}
+	if h.Protocol != 0 {
+		parts[0] += " bzImage"
+		parts = append(parts, fmt.Sprintf("boot protocol %d.%d", h.Protocol>>8, h.Protocol&0xff))
+	}
+	if h.EFIStub {
+		parts = append(parts, "EFI stub")
+	}
+	if h.EFIHandover {
+		parts = append(parts, "EFI handover")
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Check checks that the file at path is a Linux kernel image and that
+// cmdline passes CheckCmdline. The kernel header is returned, so that
+// callers can report it.
+func Check(path, cmdline string) (*Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, HeaderSize)
+	n, err := io.ReadFull(f, data)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	h, err := ParseImage(data[:n])
+	if err != nil {
+		return nil, fmt.Errorf("kernel %q: %w", path, err)
+	}
+	if err := CheckCmdline(cmdline); err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
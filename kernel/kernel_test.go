@@ -0,0 +1,163 @@
+package kernel
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testBzImage(version string) []byte {
+	data := make([]byte, HeaderSize)
+	data[offsetSetupSects] = 4
+	binary.LittleEndian.PutUint16(data[offsetBootFlag:], bootFlag)
+	copy(data[offsetHeaderMagic:], headerMagic)
+	binary.LittleEndian.PutUint16(data[offsetProtocol:], 0x20f)
+	if version != "" {
+		const ptr = 0x300
+		binary.LittleEndian.PutUint16(data[offsetKernelVersion:], ptr)
+		copy(data[ptr+kernelVersionBase:], version+"\x00")
+	}
+
+	return data
+}
+
+func TestParseHeader(t *testing.T) {
+	for _, table := range []struct {
+		name    string
+		data    []byte
+		want    *Header
+		wantErr error
+	}{
+		{
+			name: "version",
+			data: testBzImage("6.1.0-18-amd64 (debian-kernel@lists.debian.org) #1 SMP"),
+			want: &Header{Arch: "x86", Protocol: 0x20f, Version: "6.1.0-18-amd64 (debian-kernel@lists.debian.org) #1 SMP"},
+		},
+		{
+			name: "no version",
+			data: testBzImage(""),
+			want: &Header{Arch: "x86", Protocol: 0x20f},
+		},
+		{
+			name:    "not a kernel",
+			data:    make([]byte, HeaderSize),
+			wantErr: ErrNotBzImage,
+		},
+		{
+			name:    "too short",
+			data:    []byte("A dummy kernel"),
+			wantErr: ErrNotBzImage,
+		},
+	} {
+		t.Run(table.name, func(t *testing.T) {
+			got, err := ParseHeader(table.data)
+			if !errors.Is(err, table.wantErr) {
+				t.Fatalf("ParseHeader err = %v, want %v", err, table.wantErr)
+			}
+			if !reflect.DeepEqual(got, table.want) {
+				t.Errorf("ParseHeader = %+v, want %+v", got, table.want)
+			}
+		})
+	}
+}
+
+func TestSplitCmdline(t *testing.T) {
+	for _, table := range []struct {
+		cmdline string
+		want    []string
+		wantErr error
+	}{
+		{cmdline: "", want: nil},
+		{cmdline: "  console=ttyS0  quiet\n", want: []string{"console=ttyS0", "quiet"}},
+		{cmdline: `a="b c" d`, want: []string{`a="b c"`, "d"}},
+		{cmdline: `a="b c d`, want: []string{`a="b c d`}, wantErr: ErrUnbalancedQuotes},
+	} {
+		got, err := SplitCmdline(table.cmdline)
+		if !errors.Is(err, table.wantErr) {
+			t.Errorf("SplitCmdline(%q) err = %v, want %v", table.cmdline, err, table.wantErr)
+		}
+		if !reflect.DeepEqual(got, table.want) {
+			t.Errorf("SplitCmdline(%q) = %q, want %q", table.cmdline, got, table.want)
+		}
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	efiBzImage := testBzImage("6.6.0")
+	copy(efiBzImage, dosMagic)
+	binary.LittleEndian.PutUint32(efiBzImage[offsetPEHeader:], 0x40)
+	copy(efiBzImage[0x40:], peMagic)
+	binary.LittleEndian.PutUint16(efiBzImage[0x44:], 0x8664)
+	binary.LittleEndian.PutUint16(efiBzImage[offsetXLoadFlags:], xlfKernel64|xlfEFIHandover64)
+
+	arm64Image := make([]byte, 0x40)
+	copy(arm64Image[offsetImageMagic:], arm64Magic)
+
+	// A PE executable that is not a kernel, e.g., a UKI stub.
+	peImage := make([]byte, 0x100)
+	copy(peImage, dosMagic)
+	binary.LittleEndian.PutUint32(peImage[offsetPEHeader:], 0x80)
+	copy(peImage[0x80:], peMagic)
+	binary.LittleEndian.PutUint16(peImage[0x84:], 0x8664)
+
+	for _, table := range []struct {
+		name    string
+		data    []byte
+		want    *Header
+		wantErr error
+	}{
+		{
+			name: "bzImage with EFI stub",
+			data: efiBzImage,
+			want: &Header{Arch: "x86_64", Protocol: 0x20f, Version: "6.6.0", EFIStub: true, EFIHandover: true},
+		},
+		{
+			name: "arm64 Image",
+			data: arm64Image,
+			want: &Header{Arch: "arm64"},
+		},
+		{
+			name:    "PE executable",
+			data:    peImage,
+			wantErr: ErrNotKernel,
+		},
+		{
+			name:    "initramfs",
+			data:    []byte("070701000000010000"),
+			wantErr: ErrNotKernel,
+		},
+	} {
+		t.Run(table.name, func(t *testing.T) {
+			got, err := ParseImage(table.data)
+			if !errors.Is(err, table.wantErr) {
+				t.Fatalf("ParseImage err = %v, want %v", err, table.wantErr)
+			}
+			if !reflect.DeepEqual(got, table.want) {
+				t.Errorf("ParseImage = %+v, want %+v", got, table.want)
+			}
+		})
+	}
+}
+
+func TestCheckCmdline(t *testing.T) {
+	for _, table := range []struct {
+		cmdline string
+		wantErr error
+	}{
+		{cmdline: "console=ttyS0,115200 console=tty0 quiet"},
+		{cmdline: "ip=dhcp ip=eth1:dhcp -- quiet quiet"},
+		{cmdline: "systemd.setenv=A=1 systemd.setenv=B=2 rd.luks.name=a=x rd.luks.name=b=y"},
+		{cmdline: "rd.lvm.lv=vg/root rd.lvm.lv=vg/swap rd.md.uuid=1 rd.md.uuid=2 rd.driver.pre=a rd.driver.pre=b"},
+		{cmdline: "quiet quiet"},
+		{cmdline: "rootfstype=ext4 rootfstype=xfs", wantErr: ErrCmdline},
+		{cmdline: "root=/dev/sda1 quiet root=/dev/sda2", wantErr: ErrCmdline},
+		{cmdline: "console=ttyS0,115200 console=ttyS0,9600", wantErr: ErrCmdline},
+		{cmdline: "console=ttyS0 console=ttyS0"},
+		{cmdline: `init="/bin/sh`, wantErr: ErrUnbalancedQuotes},
+	} {
+		if err := CheckCmdline(table.cmdline); !errors.Is(err, table.wantErr) {
+			t.Errorf("CheckCmdline(%q) err = %v, want %v", table.cmdline, err, table.wantErr)
+		}
+	}
+}
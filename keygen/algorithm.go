@@ -0,0 +1,53 @@
+package keygen
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+// Algorithms of generated keys. OS packages can only be signed with
+// Ed25519 keys, the others are meant for Secure Boot signing.
+const (
+	AlgorithmEd25519 = "ed25519"
+	AlgorithmRSA2048 = "rsa2048"
+	AlgorithmRSA3072 = "rsa3072"
+	AlgorithmRSA4096 = "rsa4096"
+)
+
+var ErrAlgorithm = errors.New("unsupported key algorithm")
+
+var rsaKeySizes = map[string]int{
+	AlgorithmRSA2048: 2048,
+	AlgorithmRSA3072: 3072,
+	AlgorithmRSA4096: 4096,
+}
+
+func checkAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", AlgorithmEd25519, AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		return nil
+	}
+
+	return fmt.Errorf("%w %q, must be one of %s, %s, %s and %s", ErrAlgorithm, algorithm,
+		AlgorithmEd25519, AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096)
+}
+
+// GenerateKey generates a new private key. An empty algorithm means
+// AlgorithmEd25519.
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	if err := checkAlgorithm(algorithm); err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		return rsa.GenerateKey(rand.Reader, rsaKeySizes[algorithm])
+	default:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+}
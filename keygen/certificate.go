@@ -1,6 +1,7 @@
 package keygen
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ed25519"
 	"crypto/rand"
@@ -22,27 +23,34 @@ import (
 var (
 	ErrNoRootCert = errors.New("missing rootCert")
 	ErrNoRootKey  = errors.New("missing rootKey")
+	ErrPathLen    = errors.New("path length constraint violated")
 )
 
 const (
-	DefaultCertName     = "cert.pem"
-	DefaultRootCertName = "rootcert.pem"
-	DefaultKeyName      = "key.pem"
-	DefaultRootKeyName  = "rootkey.pem"
-	serialNumberRange   = 128
+	DefaultCertName             = "cert.pem"
+	DefaultRootCertName         = "rootcert.pem"
+	DefaultIntermediateCertName = "intermediatecert.pem"
+	DefaultKeyName              = "key.pem"
+	DefaultRootKeyName          = "rootkey.pem"
+	DefaultIntermediateKeyName  = "intermediatekey.pem"
+	serialNumberRange           = 128
 )
 
 // CertificateArgs is a list of arguments
 // that's passed to Certificate().
 type CertificateArgs struct {
 	IsCa           bool
+	IsIntermediate bool   // Issue an intermediate CA certificate.
+	PathLen        *int   // Path length constraint for CA certificates, nil means default.
 	IssuerCertFile string // Empty, for creating a self-signed cert.
 	IssuerKeyFile  string // Private root CA signing key.
 	LeafKeyFile    string // Public key
+	Algorithm      string // Algorithm of a generated key, see GenerateKey.
 	NotBefore      time.Time
 	NotAfter       time.Time
 	CertOut        string
 	KeyOut         string
+	ChainOut       string // Optional, the new certificate followed by its issuers.
 }
 
 // Certificate is used to create a new certificate and private
@@ -53,14 +61,22 @@ func Certificate(args *CertificateArgs) error {
 		return err
 	}
 
+	defaultCertName, defaultKeyName := DefaultCertName, DefaultKeyName
+	switch {
+	case args.IsCa:
+		defaultCertName, defaultKeyName = DefaultRootCertName, DefaultRootKeyName
+	case args.IsIntermediate:
+		defaultCertName, defaultKeyName = DefaultIntermediateCertName, DefaultIntermediateKeyName
+	}
+
 	// Evaluate the path for the private key.
-	keyOut, err := parseKeyPath(args.IsCa, args.KeyOut)
+	keyOut, err := parseOutPath(defaultKeyName, args.KeyOut)
 	if err != nil {
 		return err
 	}
 
 	// Evaluate the path for the certificate.
-	args.CertOut, err = parseCertPath(args.IsCa, args.CertOut)
+	args.CertOut, err = parseOutPath(defaultCertName, args.CertOut)
 	if err != nil {
 		return err
 	}
@@ -68,6 +84,7 @@ func Certificate(args *CertificateArgs) error {
 	var (
 		newCert []byte
 		newKey  crypto.Signer
+		chain   [][]byte
 	)
 
 	if args.IssuerCertFile == "" {
@@ -77,36 +94,69 @@ func Certificate(args *CertificateArgs) error {
 		if args.IssuerKeyFile != "" {
 			signer, err = LoadPrivateKey(args.IssuerKeyFile)
 		} else {
-			_, signer, err = ed25519.GenerateKey(rand.Reader)
+			signer, err = GenerateKey(args.Algorithm)
 			newKey = signer
 		}
 		if err != nil {
 			return err
 		}
-		newCert, err = newCaCert(signer, args.NotBefore, args.NotAfter)
+		defer CloseSigner(signer)
+		pathLen := -1 // No constraint.
+		if args.PathLen != nil {
+			pathLen = *args.PathLen
+		}
+		newCert, err = newCaCert(signer, pathLen, args.NotBefore, args.NotAfter)
 		if err != nil {
 			return err
 		}
 	} else {
-		rootCert, rootKey, err := parseCaFiles(args.IssuerCertFile, args.IssuerKeyFile)
+		issuerChain, rootKey, err := parseCaFiles(args.IssuerCertFile, args.IssuerKeyFile)
 		if err != nil {
 			return err
 		}
+		defer CloseSigner(rootKey)
+		rootCert := issuerChain[0]
+		pathLen := 0
+		if args.PathLen != nil {
+			pathLen = *args.PathLen
+		}
+		if err := checkIssuer(rootCert, args.IsIntermediate, pathLen); err != nil {
+			return err
+		}
 		var leafPublicKey crypto.PublicKey
 
 		if args.LeafKeyFile != "" {
 			leafPublicKey, err = LoadPublicKey(args.LeafKeyFile)
 		} else {
-			leafPublicKey, newKey, err = ed25519.GenerateKey(rand.Reader)
+			newKey, err = GenerateKey(args.Algorithm)
 		}
 		if err != nil {
 			return err
 		}
-		// Create a certificate signed by a root certificate.
-		newCert, err = newSigningCert(rootCert, rootKey, leafPublicKey, args.NotBefore, args.NotAfter)
+		if newKey != nil {
+			leafPublicKey = newKey.Public()
+		}
+		if args.IsIntermediate {
+			// Create an intermediate CA certificate, signed by
+			// the issuer.
+			newCert, err = newIntermediateCert(rootCert, rootKey, leafPublicKey, pathLen, args.NotBefore, args.NotAfter)
+		} else {
+			if !isSelfSigned(rootCert) {
+				stlog.Warn("Issuing from intermediate CA %s: stboot verifies OS package signatures directly"+
+					" against ospkg_signing_root.pem, which must then include the intermediate certificate",
+					rootCert.Subject)
+			}
+			// Create a certificate signed by a root certificate.
+			newCert, err = newSigningCert(rootCert, rootKey, leafPublicKey, args.NotBefore, args.NotAfter)
+		}
 		if err != nil {
 			return err
 		}
+		for _, cert := range issuerChain {
+			if !isSelfSigned(cert) {
+				chain = append(chain, cert.Raw)
+			}
+		}
 	}
 
 	if newKey != nil {
@@ -114,17 +164,42 @@ func Certificate(args *CertificateArgs) error {
 			return err
 		}
 	}
-	return writeCert(newCert, args.CertOut)
+	if err := writeCert(newCert, args.CertOut); err != nil {
+		return err
+	}
+	if args.ChainOut != "" {
+		return writeChain(append([][]byte{newCert}, chain...), args.ChainOut)
+	}
+
+	return nil
 }
 
 func checkArgs(args *CertificateArgs) error {
+	if args.PathLen != nil && *args.PathLen < 0 {
+		return fmt.Errorf("invalid path length %d", *args.PathLen)
+	}
+	if args.Algorithm != "" {
+		if err := checkAlgorithm(args.Algorithm); err != nil {
+			return err
+		}
+		// The algorithm only applies to a generated key.
+		if (args.IsCa && args.IssuerKeyFile != "") || (!args.IsCa && args.LeafKeyFile != "") {
+			return errors.New("algorithm cannot be set for an existing key")
+		}
+	}
 	if args.IsCa {
+		if args.IsIntermediate {
+			return errors.New("isCA and intermediate are mutually exclusive")
+		}
 		if args.IssuerCertFile != "" {
 			stlog.Warn("isCA specified, will ignore rootCert")
 			args.IssuerCertFile = ""
 		}
 		return nil
 	}
+	if args.PathLen != nil && !args.IsIntermediate {
+		return errors.New("a path length constraint can only be set for CA certificates")
+	}
 	// For generating non-CA certs, either both key and cert must
 	// be provided, or none (in which case default filenames are
 	// used).
@@ -135,9 +210,38 @@ func checkArgs(args *CertificateArgs) error {
 	if args.IssuerKeyFile == "" && args.IssuerCertFile != "" {
 		return ErrNoRootKey
 	}
+	// An intermediate is never self-signed.
+	if args.IsIntermediate && args.IssuerCertFile == "" {
+		return ErrNoRootCert
+	}
 	return nil
 }
 
+// checkIssuer checks that issuer may issue the new certificate: it
+// must be a CA, and for a new intermediate CA, the issuer's path length
+// constraint must allow one more CA below it, with a smaller
+// constraint.
+func checkIssuer(issuer *x509.Certificate, intermediate bool, pathLen int) error {
+	if !issuer.IsCA {
+		return fmt.Errorf("issuer %s is not a CA certificate", issuer.Subject)
+	}
+	if !intermediate || (issuer.MaxPathLen <= 0 && !issuer.MaxPathLenZero) {
+		return nil
+	}
+	if issuer.MaxPathLen == 0 {
+		return fmt.Errorf("%w: issuer %s may not issue CA certificates", ErrPathLen, issuer.Subject)
+	}
+	if pathLen >= issuer.MaxPathLen {
+		return fmt.Errorf("%w: path length %d must be less than issuer's %d", ErrPathLen, pathLen, issuer.MaxPathLen)
+	}
+
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
 func writeCert(cert []byte, certOut string) error {
 	return WritePEM(&pem.Block{
 		Type:  "CERTIFICATE",
@@ -145,6 +249,15 @@ func writeCert(cert []byte, certOut string) error {
 	}, certOut)
 }
 
+func writeChain(chain [][]byte, chainOut string) error {
+	var data []byte
+	for _, cert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+
+	return os.WriteFile(chainOut, data, defaultFilePerm)
+}
+
 // This function makes sure the on-disk format of the key and certificate are correct.
 func writeKey(key crypto.Signer, keyOut string) error {
 	marshaledKey, err := x509.MarshalPKCS8PrivateKey(key)
@@ -158,25 +271,33 @@ func writeKey(key crypto.Signer, keyOut string) error {
 	}, keyOut)
 }
 
-func parseCaFiles(rootCertPath, rootKeyPath string) (*x509.Certificate, crypto.Signer, error) {
+// parseCaFiles loads the issuer's certificate, possibly followed by
+// the rest of its chain, and the issuer's signing key.
+func parseCaFiles(rootCertPath, rootKeyPath string) ([]*x509.Certificate, crypto.Signer, error) {
 	rootCertPath, err := filepath.Abs(rootCertPath)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	rootCertDER, err := LoadCertBytes(rootCertPath)
+	chainDER, err := LoadCertChain(rootCertPath)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	rootCert, err := x509.ParseCertificate(rootCertDER)
-	if err != nil {
-		return nil, nil, err
+	var chain []*x509.Certificate
+	for _, der := range chainDER {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, cert)
 	}
 
-	rootKeyPath, err = filepath.Abs(rootKeyPath)
-	if err != nil {
-		return nil, nil, err
+	if !isPKCS11URI(rootKeyPath) && !isSignerSpec(rootKeyPath) {
+		rootKeyPath, err = filepath.Abs(rootKeyPath)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	rootKey, err := LoadPrivateKey(rootKeyPath)
@@ -184,37 +305,12 @@ func parseCaFiles(rootCertPath, rootKeyPath string) (*x509.Certificate, crypto.S
 		return nil, nil, err
 	}
 
-	return rootCert, rootKey, nil
+	return chain, rootKey, nil
 }
 
-func parseKeyPath(isCA bool, path string) (string, error) {
+func parseOutPath(defaultName, path string) (string, error) {
 	if path == "" {
-		if isCA {
-			return DefaultRootKeyName, nil
-		}
-
-		return DefaultKeyName, nil
-	}
-
-	if _, err := os.Stat(filepath.Dir(path)); err != nil {
-		return "", err
-	}
-
-	path, err := filepath.Abs(path)
-	if err != nil {
-		return "", err
-	}
-
-	return path, nil
-}
-
-func parseCertPath(isCA bool, path string) (string, error) {
-	if path == "" {
-		if isCA {
-			return DefaultRootCertName, nil
-		}
-
-		return DefaultCertName, nil
+		return defaultName, nil
 	}
 
 	if _, err := os.Stat(filepath.Dir(path)); err != nil {
@@ -235,18 +331,40 @@ func randomSerial() (*big.Int, error) {
 	return rand.Int(rand.Reader, serialNumberLimit)
 }
 
-func hashPublicKey(pub crypto.PublicKey) (string, error) {
-	ed25519pub, ok := pub.(ed25519.PublicKey)
+// HashPublicKey returns the base64 encoded SHA-256 hash of a public
+// key, as used for the CommonName of generated certificates. For
+// Ed25519 keys, the raw key is hashed, as for Sigsum key hashes, and
+// for other keys, the DER encoded SubjectPublicKeyInfo.
+func HashPublicKey(pub crypto.PublicKey) (string, error) {
+	data, ok := pub.(ed25519.PublicKey)
 	if !ok {
-		return "", fmt.Errorf("not ed25519")
+		var err error
+		data, err = x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
 	}
-	hash := sha256.Sum256(ed25519pub)
+	hash := sha256.Sum256(data)
 	return base64.StdEncoding.EncodeToString(hash[:]), nil
 }
 
-// Create a new self-signed certificate.
-func newCaCert(signer crypto.Signer, notBefore, notAfter time.Time) ([]byte, error) {
-	keyHash, err := hashPublicKey(signer.Public())
+// extKeyUsage returns the extended key usage of leaf certificates for
+// a key. Keys other than Ed25519 are for Secure Boot signing, where
+// firmware expects code signing certificates in db. OS package
+// certificates are left without extended key usage, so that they are
+// accepted for any usage, and so are CA certificates.
+func extKeyUsage(publicKey crypto.PublicKey) []x509.ExtKeyUsage {
+	if _, ok := publicKey.(ed25519.PublicKey); ok {
+		return nil
+	}
+
+	return []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+}
+
+// Create a new self-signed certificate. A negative pathLen means no
+// path length constraint.
+func newCaCert(signer crypto.Signer, pathLen int, notBefore, notAfter time.Time) ([]byte, error) {
+	keyHash, err := HashPublicKey(signer.Public())
 	if err != nil {
 		return nil, err
 	}
@@ -264,12 +382,41 @@ func newCaCert(signer crypto.Signer, notBefore, notAfter time.Time) ([]byte, err
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
 	}
+	if pathLen >= 0 {
+		template.MaxPathLen = pathLen
+		template.MaxPathLenZero = pathLen == 0
+	}
 	return x509.CreateCertificate(rand.Reader, &template, &template, signer.Public(), signer)
 }
 
+// Creates a new intermediate CA certificate, signed by the CA key.
+func newIntermediateCert(caCert *x509.Certificate, caSigner crypto.Signer, publicKey crypto.PublicKey, pathLen int, notBefore, notAfter time.Time) ([]byte, error) {
+	keyHash, err := HashPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		Issuer:                caCert.Subject,
+		Subject:               pkix.Name{CommonName: keyHash}, // anything that is unique
+		SerialNumber:          serialNumber,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+	}
+	return x509.CreateCertificate(rand.Reader, &template, caCert, publicKey, caSigner)
+}
+
 // Creates a new signing certificate, signed by the CA key.
 func newSigningCert(caCert *x509.Certificate, caSigner crypto.Signer, leafPublicKey crypto.PublicKey, notBefore, notAfter time.Time) ([]byte, error) {
-	keyHash, err := hashPublicKey(leafPublicKey)
+	keyHash, err := HashPublicKey(leafPublicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -285,5 +432,6 @@ func newSigningCert(caCert *x509.Certificate, caSigner crypto.Signer, leafPublic
 		NotBefore:    notBefore,
 		NotAfter:     notAfter,
 	}
+	template.ExtKeyUsage = extKeyUsage(leafPublicKey)
 	return x509.CreateCertificate(rand.Reader, &template, caCert, leafPublicKey, caSigner)
 }
@@ -0,0 +1,144 @@
+package keygen
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCertificateIntermediate(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	one := 1
+	now := time.Now()
+	notAfter := now.Add(time.Hour)
+
+	for _, args := range []*CertificateArgs{
+		{IsCa: true, PathLen: &one, CertOut: path("root.pem"), KeyOut: path("root.key")},
+		{
+			IsIntermediate: true, IssuerCertFile: path("root.pem"), IssuerKeyFile: path("root.key"),
+			CertOut: path("team.pem"), KeyOut: path("team.key"), ChainOut: path("team.chain"),
+		},
+		{
+			IssuerCertFile: path("team.chain"), IssuerKeyFile: path("team.key"),
+			CertOut: path("leaf.pem"), KeyOut: path("leaf.key"), ChainOut: path("leaf.chain"),
+		},
+	} {
+		args.NotBefore, args.NotAfter = now, notAfter
+		if err := Certificate(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loadChain := func(name string) []*x509.Certificate {
+		chainDER, err := LoadCertChain(path(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var chain []*x509.Certificate
+		for _, der := range chainDER {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain = append(chain, cert)
+		}
+		return chain
+	}
+
+	team := loadChain("team.pem")[0]
+	if !team.IsCA || team.MaxPathLen != 0 || !team.MaxPathLenZero {
+		t.Errorf("intermediate: IsCA %v, MaxPathLen %d, MaxPathLenZero %v, want true, 0, true",
+			team.IsCA, team.MaxPathLen, team.MaxPathLenZero)
+	}
+
+	chain := loadChain("leaf.chain")
+	if len(chain) != 2 || !chain[1].Equal(team) {
+		t.Fatalf("leaf chain has %d certificates, want leaf and intermediate", len(chain))
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(loadChain("root.pem")[0])
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(chain[1])
+	if _, err := chain[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Errorf("leaf does not verify: %v", err)
+	}
+
+	// The intermediate may not issue further CAs.
+	err := Certificate(&CertificateArgs{
+		IsIntermediate: true, IssuerCertFile: path("team.pem"), IssuerKeyFile: path("team.key"),
+		CertOut: path("sub.pem"), KeyOut: path("sub.key"), NotBefore: now, NotAfter: notAfter,
+	})
+	if !errors.Is(err, ErrPathLen) {
+		t.Errorf("got err %v, want %v", err, ErrPathLen)
+	}
+}
+
+func TestCertificateAlgorithm(t *testing.T) {
+	now := time.Now()
+	for _, table := range []struct {
+		algorithm string
+		wantEKU   []x509.ExtKeyUsage
+	}{
+		{algorithm: AlgorithmEd25519},
+		{algorithm: AlgorithmRSA2048, wantEKU: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}},
+	} {
+		t.Run(table.algorithm, func(t *testing.T) {
+			dir := t.TempDir()
+			rootCert, rootKey := filepath.Join(dir, "root.pem"), filepath.Join(dir, "root.key")
+			leafCert, leafKey := filepath.Join(dir, "leaf.pem"), filepath.Join(dir, "leaf.key")
+			for _, args := range []*CertificateArgs{
+				{IsCa: true, CertOut: rootCert, KeyOut: rootKey},
+				{IssuerCertFile: rootCert, IssuerKeyFile: rootKey, CertOut: leafCert, KeyOut: leafKey},
+			} {
+				args.Algorithm, args.NotBefore, args.NotAfter = table.algorithm, now, now.Add(time.Hour)
+				if err := Certificate(args); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			der, err := LoadCertBytes(leafCert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer, err := LoadPrivateKey(leafKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+				t.Errorf("certificate does not match key")
+			}
+			keyHash, err := HashPublicKey(cert.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cert.Subject.CommonName != keyHash {
+				t.Errorf("got subject %q, want key hash %q", cert.Subject.CommonName, keyHash)
+			}
+			if !reflect.DeepEqual(cert.ExtKeyUsage, table.wantEKU) {
+				t.Errorf("got extended key usage %v, want %v", cert.ExtKeyUsage, table.wantEKU)
+			}
+
+			// CA certificates are not restricted to code signing.
+			der, err = LoadCertBytes(rootCert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			root, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(root.ExtKeyUsage) != 0 {
+				t.Errorf("got extended key usage %v for CA certificate", root.ExtKeyUsage)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package keygen
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
+	"system-transparency.org/stmgr/internal/errwriter"
+)
+
+// Kinds of inspected files.
+const (
+	KindCertificate = "certificate"
+	KindPublicKey   = "public key"
+	KindPrivateKey  = "private key"
+)
+
+var ErrNoChain = errors.New("certificate does not chain to root")
+
+// InspectArgs is a list of arguments
+// that's passed to Inspect().
+type InspectArgs struct {
+	Files []string
+	// RootCerts, if set, is a file of root certificates that
+	// certificates are verified against.
+	RootCerts string
+	JSON      bool
+	Now       time.Time
+	Out       io.Writer
+}
+
+// KeyInspection describes a certificate or key file, as reported by
+// Inspect.
+type KeyInspection struct {
+	File          string `json:"file"`
+	Kind          string `json:"kind"`
+	KeyType       string `json:"key_type"`
+	KeyHash       string `json:"key_hash"`
+	SigsumKeyHash string `json:"sigsum_key_hash,omitempty"`
+	// Agent tells if ssh-agent holds the private key, for OpenSSH
+	// public key files, which refer to a key in ssh-agent.
+	Agent       string          `json:"ssh_agent,omitempty"`
+	Certificate *CertInspection `json:"certificate,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+type CertInspection struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	IsCA        bool      `json:"is_ca"`
+	MaxPathLen  *int      `json:"max_path_len,omitempty"`
+	KeyUsage    []string  `json:"key_usage,omitempty"`
+	ExtKeyUsage []string  `json:"ext_key_usage,omitempty"`
+	// Chain is the result of verification against the root
+	// certificates, if any.
+	Chain *ChainInspection `json:"chain,omitempty"`
+}
+
+type ChainInspection struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+	// ValidUntil is when the first certificate of the chain
+	// expires.
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digital signature"},
+	{x509.KeyUsageContentCommitment, "content commitment"},
+	{x509.KeyUsageKeyEncipherment, "key encipherment"},
+	{x509.KeyUsageDataEncipherment, "data encipherment"},
+	{x509.KeyUsageKeyAgreement, "key agreement"},
+	{x509.KeyUsageCertSign, "certificate signing"},
+	{x509.KeyUsageCRLSign, "CRL signing"},
+	{x509.KeyUsageEncipherOnly, "encipher only"},
+	{x509.KeyUsageDecipherOnly, "decipher only"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "server authentication",
+	x509.ExtKeyUsageClientAuth:      "client authentication",
+	x509.ExtKeyUsageCodeSigning:     "code signing",
+	x509.ExtKeyUsageEmailProtection: "email protection",
+	x509.ExtKeyUsageTimeStamping:    "time stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP signing",
+}
+
+// Inspect describes certificate and key files: the key type and
+// hashes, and for certificates, validity, CA constraints and usage,
+// and whether they chain to the root certificates. Files are tried as
+// certificates, public keys and private keys, in that order. An error
+// is returned, after writing the report, if any file could not be
+// loaded or any certificate does not chain to the roots.
+func Inspect(args *InspectArgs) error {
+	if len(args.Files) == 0 {
+		return errors.New("no files to inspect")
+	}
+	now := args.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var roots *x509.CertPool
+	if args.RootCerts != "" {
+		var err error
+		if roots, err = loadCertPool(args.RootCerts); err != nil {
+			return err
+		}
+	}
+
+	var (
+		infos   []*KeyInspection
+		failure error
+	)
+	for _, file := range args.Files {
+		fileInfos, err := inspectFile(file, roots, now)
+		if err != nil {
+			fileInfos = []*KeyInspection{{File: file, Error: err.Error()}}
+			failure = fmt.Errorf("%q: %w", file, err)
+		}
+		for _, info := range fileInfos {
+			if info.Certificate != nil && info.Certificate.Chain != nil && !info.Certificate.Chain.Valid && failure == nil {
+				failure = fmt.Errorf("%q: %w", file, ErrNoChain)
+			}
+		}
+		infos = append(infos, fileInfos...)
+	}
+
+	if args.JSON {
+		pretty, err := json.MarshalIndent(infos, "", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := args.Out.Write(append(pretty, '\n')); err != nil {
+			return err
+		}
+	} else if err := writeInspections(args.Out, infos, now); err != nil {
+		return err
+	}
+
+	return failure
+}
+
+func inspectFile(file string, roots *x509.CertPool, now time.Time) ([]*KeyInspection, error) {
+	if !isPKCS11URI(file) && !isSignerSpec(file) {
+		if chain, err := LoadCertChain(file); err == nil {
+			return inspectCerts(file, chain, roots, now)
+		}
+	}
+
+	if pub, err := LoadPublicKey(file); err == nil {
+		info, err := inspectKey(file, KindPublicKey, pub)
+		if err != nil {
+			return nil, err
+		}
+		if data, err := os.ReadFile(file); err == nil && bytes.HasPrefix(data, []byte("ssh-ed25519 ")) {
+			info.Agent = agentStatus(pub)
+		}
+		if isPKCS11URI(file) || isSignerSpec(file) {
+			info.Kind = KindPrivateKey
+		}
+
+		return []*KeyInspection{info}, nil
+	}
+
+	signer, err := LoadPrivateKey(file)
+	if err != nil {
+		return nil, fmt.Errorf("not a certificate, public key or private key: %w", err)
+	}
+	defer CloseSigner(signer)
+	info, err := inspectKey(file, KindPrivateKey, signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return []*KeyInspection{info}, nil
+}
+
+// inspectCerts inspects the certificates of a file, usually one, or a
+// certificate followed by its intermediates.
+func inspectCerts(file string, chain [][]byte, roots *x509.CertPool, now time.Time) ([]*KeyInspection, error) {
+	var certs []*x509.Certificate
+	intermediates := x509.NewCertPool()
+	for _, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+		intermediates.AddCert(cert)
+	}
+
+	var infos []*KeyInspection
+	for _, cert := range certs {
+		info, err := inspectKey(file, KindCertificate, cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		info.Certificate = inspectCert(cert)
+		if roots != nil {
+			info.Certificate.Chain = verifyChain(cert, roots, intermediates, now)
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func inspectKey(file, kind string, pub crypto.PublicKey) (*KeyInspection, error) {
+	keyHash, err := HashPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	info := &KeyInspection{
+		File:    file,
+		Kind:    kind,
+		KeyType: keyType(pub),
+		KeyHash: keyHash,
+	}
+	if ed25519Key, ok := pub.(ed25519.PublicKey); ok {
+		hash := sigsumCrypto.HashBytes(ed25519Key)
+		info.SigsumKeyHash = hex.EncodeToString(hash[:])
+	}
+
+	return info, nil
+}
+
+func inspectCert(cert *x509.Certificate) *CertInspection {
+	info := &CertInspection{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		IsCA:      cert.IsCA,
+	}
+	if cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		pathLen := cert.MaxPathLen
+		info.MaxPathLen = &pathLen
+	}
+	for _, ku := range keyUsageNames {
+		if cert.KeyUsage&ku.usage != 0 {
+			info.KeyUsage = append(info.KeyUsage, ku.name)
+		}
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		name, ok := extKeyUsageNames[eku]
+		if !ok {
+			name = fmt.Sprintf("unknown (%d)", eku)
+		}
+		info.ExtKeyUsage = append(info.ExtKeyUsage, name)
+	}
+
+	return info
+}
+
+// verifyChain verifies the certificate against the roots, as of now,
+// for any key usage, as stboot does.
+func verifyChain(cert *x509.Certificate, roots, intermediates *x509.CertPool, now time.Time) *ChainInspection {
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return &ChainInspection{Error: err.Error()}
+	}
+
+	// Report the chain that stays valid the longest.
+	var best time.Time
+	for _, chain := range chains {
+		validUntil := chain[0].NotAfter
+		for _, c := range chain[1:] {
+			if c.NotAfter.Before(validUntil) {
+				validUntil = c.NotAfter
+			}
+		}
+		if validUntil.After(best) {
+			best = validUntil
+		}
+	}
+
+	return &ChainInspection{Valid: true, ValidUntil: &best}
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	chain, err := LoadCertChain(path)
+	if err != nil {
+		return nil, fmt.Errorf("root certificates %q: %w", path, err)
+	}
+	pool := x509.NewCertPool()
+	for _, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("root certificates %q: %w", path, err)
+		}
+		pool.AddCert(cert)
+	}
+
+	return pool, nil
+}
+
+func keyType(pub crypto.PublicKey) string {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return "Ed25519"
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d bit", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+// agentStatus tells if ssh-agent holds the private key for pub.
+func agentStatus(pub crypto.PublicKey) string {
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return err.Error()
+	}
+	conn, err := dialAgent()
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v", err)
+	}
+	for _, key := range keys {
+		if bytes.Equal(key.Blob, sshPub.Marshal()) {
+			return "holds key"
+		}
+	}
+
+	return "does not hold key"
+}
+
+func writeInspections(out io.Writer, infos []*KeyInspection, now time.Time) error {
+	w := &errwriter.Writer{W: out}
+
+	for i, info := range infos {
+		if i > 0 {
+			w.Printf("\n")
+		}
+		w.Printf("%s:\n", info.File)
+		if info.Error != "" {
+			w.Printf("  Error:           %s\n", info.Error)
+			continue
+		}
+		w.Printf("  Kind:            %s\n", info.Kind)
+		w.Printf("  Key type:        %s\n", info.KeyType)
+		w.Printf("  Key hash:        %s\n", info.KeyHash)
+		if info.SigsumKeyHash != "" {
+			w.Printf("  Sigsum key hash: %s\n", info.SigsumKeyHash)
+		}
+		if info.Agent != "" {
+			w.Printf("  ssh-agent:       %s\n", info.Agent)
+		}
+		if cert := info.Certificate; cert != nil {
+			w.Printf("  Subject:         %s\n", cert.Subject)
+			w.Printf("  Issuer:          %s\n", cert.Issuer)
+			w.Printf("  Valid:           %s to %s (%s)\n", cert.NotBefore.UTC().Format(time.RFC3339),
+				cert.NotAfter.UTC().Format(time.RFC3339), validity(cert.NotBefore, cert.NotAfter, now))
+			ca := "no"
+			if cert.IsCA {
+				ca = "yes"
+				if cert.MaxPathLen != nil {
+					ca += fmt.Sprintf(", path length %d", *cert.MaxPathLen)
+				}
+			}
+			w.Printf("  CA:              %s\n", ca)
+			if len(cert.KeyUsage) > 0 {
+				w.Printf("  Key usage:       %s\n", strings.Join(cert.KeyUsage, ", "))
+			}
+			if len(cert.ExtKeyUsage) > 0 {
+				w.Printf("  Ext. key usage:  %s\n", strings.Join(cert.ExtKeyUsage, ", "))
+			}
+			if chain := cert.Chain; chain != nil {
+				if chain.Valid && chain.ValidUntil != nil {
+					w.Printf("  Chains to root:  yes, until %s (%s)\n", chain.ValidUntil.UTC().Format(time.RFC3339),
+						remaining(chain.ValidUntil.Sub(now)))
+				} else {
+					w.Printf("  Chains to root:  no, %s\n", chain.Error)
+				}
+			}
+		}
+	}
+
+	return w.Err
+}
+
+func validity(notBefore, notAfter, now time.Time) string {
+	switch {
+	case now.Before(notBefore):
+		return "not yet valid"
+	case now.After(notAfter):
+		return "expired"
+	default:
+		return remaining(notAfter.Sub(now))
+	}
+}
+
+// remaining formats a duration as days and hours left.
+func remaining(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	if days == 0 {
+		return fmt.Sprintf("%dh left", hours)
+	}
+
+	return fmt.Sprintf("%dd %dh left", days, hours)
+}
@@ -0,0 +1,77 @@
+package keygen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	now := time.Now()
+	for _, args := range []*CertificateArgs{
+		{IsCa: true, CertOut: path("root.pem"), KeyOut: path("root.key")},
+		{IsCa: true, CertOut: path("other.pem"), KeyOut: path("other.key")},
+		{IssuerCertFile: path("root.pem"), IssuerKeyFile: path("root.key"), CertOut: path("leaf.pem"), KeyOut: path("leaf.key")},
+	} {
+		args.NotBefore, args.NotAfter = now, now.Add(2*time.Hour)
+		if err := Certificate(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Keypair(&KeypairArgs{Formats: []string{FormatOpenSSH}, Out: path("ssh")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	var out bytes.Buffer
+	err := Inspect(&InspectArgs{
+		Files:     []string{path("leaf.pem"), path("leaf.key"), path("ssh.pub")},
+		RootCerts: path("root.pem"),
+		JSON:      true,
+		Now:       now.Add(time.Hour),
+		Out:       &out,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var infos []KeyInspection
+	if err := json.Unmarshal(out.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("got %d inspections, want 3", len(infos))
+	}
+	cert, key, ssh := infos[0], infos[1], infos[2]
+	if cert.Kind != KindCertificate || key.Kind != KindPrivateKey || ssh.Kind != KindPublicKey {
+		t.Errorf("got kinds %q, %q, %q", cert.Kind, key.Kind, ssh.Kind)
+	}
+	if cert.KeyHash != key.KeyHash || cert.SigsumKeyHash == "" || cert.SigsumKeyHash != key.SigsumKeyHash {
+		t.Errorf("certificate and key hashes differ: %+v, %+v", cert, key)
+	}
+	if chain := cert.Certificate.Chain; chain == nil || !chain.Valid || !chain.ValidUntil.Equal(cert.Certificate.NotAfter) {
+		t.Errorf("unexpected chain: %+v", chain)
+	}
+	if ssh.Agent == "" {
+		t.Errorf("missing ssh-agent status")
+	}
+
+	// The same, with the wrong root, and after expiry.
+	for _, args := range []*InspectArgs{
+		{Files: []string{path("leaf.pem")}, RootCerts: path("other.pem"), Now: now},
+		{Files: []string{path("leaf.pem")}, RootCerts: path("root.pem"), Now: now.Add(3 * time.Hour)},
+	} {
+		out.Reset()
+		args.Out = &out
+		if err := Inspect(args); !errors.Is(err, ErrNoChain) {
+			t.Errorf("got %v, want %v", err, ErrNoChain)
+		}
+		if !bytes.Contains(out.Bytes(), []byte("Chains to root:  no")) {
+			t.Errorf("unexpected output: %s", out.String())
+		}
+	}
+}
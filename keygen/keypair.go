@@ -0,0 +1,229 @@
+package keygen
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"system-transparency.org/stboot/stlog"
+)
+
+// Key pair file formats.
+const (
+	FormatPEM     = "pem"
+	FormatOpenSSH = "openssh"
+)
+
+const (
+	publicKeyFilePerm fs.FileMode = 0o644
+	// Public key file suffixes, appended to the output prefix.
+	openSSHPublicSuffix = ".pub"
+	pemPublicSuffix     = ".pub.pem"
+)
+
+var ErrNoAgent = errors.New("no ssh-agent, SSH_AUTH_SOCK is not set")
+
+// KeypairArgs is a list of arguments
+// that's passed to Keypair().
+type KeypairArgs struct {
+	// Formats lists FormatPEM and/or FormatOpenSSH. The private
+	// key is written in the first format, and a public key file
+	// is written for each format.
+	Formats []string
+	// Out is the private key file. Public keys are written to
+	// Out.pub in OpenSSH format, and Out.pub.pem in PKIX PEM.
+	Out string
+	// Agent adds the private key to the ssh-agent at
+	// $SSH_AUTH_SOCK.
+	Agent bool
+	// Algorithm of the key, see GenerateKey. OpenSSH format and
+	// ssh-agent are only supported for Ed25519, as stmgr only
+	// uses those for Ed25519 keys.
+	Algorithm string
+	// KeyHash, the hash used in generated certificates, is
+	// printed here.
+	KeyHash io.Writer
+}
+
+// Keypair generates a new key pair, without a certificate. Existing
+// files are never overwritten.
+func Keypair(args *KeypairArgs) error {
+	if args.Out == "" {
+		return errors.New("missing output file")
+	}
+	if len(args.Formats) == 0 {
+		return errors.New("missing key format")
+	}
+	if err := checkAlgorithm(args.Algorithm); err != nil {
+		return err
+	}
+	if args.Algorithm != "" && args.Algorithm != AlgorithmEd25519 {
+		if slices.Contains(args.Formats, FormatOpenSSH) || args.Agent {
+			return fmt.Errorf("OpenSSH format and ssh-agent are only supported for %s keys", AlgorithmEd25519)
+		}
+	}
+	// Check up front, so that no files are left behind if a
+	// public key file exists.
+	paths := []string{args.Out}
+	for _, format := range args.Formats {
+		switch format {
+		case FormatOpenSSH:
+			paths = append(paths, args.Out+openSSHPublicSuffix)
+		case FormatPEM:
+			paths = append(paths, args.Out+pemPublicSuffix)
+		default:
+			return fmt.Errorf("invalid key format %q, must be %s or %s", format, FormatPEM, FormatOpenSSH)
+		}
+	}
+	for i, path := range paths {
+		if slices.Contains(paths[:i], path) {
+			return fmt.Errorf("key format given more than once")
+		}
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("%q already exists", path)
+		}
+	}
+
+	priv, err := GenerateKey(args.Algorithm)
+	if err != nil {
+		return err
+	}
+	pub := priv.Public()
+	comment := filepath.Base(args.Out)
+
+	privateKey, err := marshalPrivateKey(priv, args.Formats[0], comment)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyFiles(paths, privateKey, pub, args.Formats, comment); err != nil {
+		return err
+	}
+
+	if args.Agent {
+		if err := addToAgent(priv, comment); err != nil {
+			return fmt.Errorf("adding key to ssh-agent: %w", err)
+		}
+		stlog.Info("Added key to ssh-agent")
+	}
+
+	keyHash, err := HashPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	if args.KeyHash != nil {
+		if _, err := fmt.Fprintln(args.KeyHash, keyHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeKeyFiles writes the private key to the first path, and the
+// public key in each of the formats to the remaining paths. If any of
+// the writes fails, the files already written are removed again.
+func writeKeyFiles(paths []string, privateKey []byte, pub crypto.PublicKey, formats []string, comment string) error {
+	files := [][]byte{privateKey}
+	for _, format := range formats {
+		switch format {
+		case FormatOpenSSH:
+			sshPub, err := ssh.NewPublicKey(pub)
+			if err != nil {
+				return err
+			}
+			files = append(files, []byte(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))+" "+comment+"\n"))
+		case FormatPEM:
+			der, err := x509.MarshalPKIXPublicKey(pub)
+			if err != nil {
+				return err
+			}
+			files = append(files, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		}
+	}
+
+	for i, data := range files {
+		perm := publicKeyFilePerm
+		if i == 0 {
+			perm = defaultFilePerm
+		}
+		if err := writeNewFile(paths[i], data, perm); err != nil {
+			for _, path := range paths[:i] {
+				if rmErr := os.Remove(path); rmErr != nil {
+					stlog.Warn("Removing %q: %v", path, rmErr)
+				}
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func marshalPrivateKey(priv crypto.Signer, format, comment string) ([]byte, error) {
+	if format == FormatOpenSSH {
+		block, err := ssh.MarshalPrivateKey(priv, comment)
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(block), nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func addToAgent(priv crypto.Signer, comment string) error {
+	conn, err := dialAgent()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return agent.NewClient(conn).Add(agent.AddedKey{PrivateKey: priv, Comment: comment})
+}
+
+// dialAgent connects to the ssh-agent at $SSH_AUTH_SOCK.
+func dialAgent() (net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, ErrNoAgent
+	}
+
+	return net.Dial("unix", socket)
+}
+
+// writeNewFile writes data to a file that must not already exist. The
+// file is removed again if writing fails.
+func writeNewFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package keygen
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeypair(t *testing.T) {
+	for _, formats := range [][]string{
+		{FormatPEM},
+		{FormatOpenSSH},
+		{FormatOpenSSH, FormatPEM},
+	} {
+		t.Run(strings.Join(formats, ","), func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "key")
+			var keyHash bytes.Buffer
+			if err := Keypair(&KeypairArgs{Formats: formats, Out: out, KeyHash: &keyHash}); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Stat(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != defaultFilePerm {
+				t.Errorf("private key mode %v, want %v", info.Mode().Perm(), defaultFilePerm)
+			}
+			signer, err := LoadPrivateKey(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := HashPublicKey(signer.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(keyHash.String()); got != want {
+				t.Errorf("printed key hash %q, want %q", got, want)
+			}
+
+			for _, format := range formats {
+				suffix := map[string]string{FormatPEM: pemPublicSuffix, FormatOpenSSH: openSSHPublicSuffix}[format]
+				pub, err := LoadPublicKey(out + suffix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !signer.Public().(ed25519.PublicKey).Equal(pub) {
+					t.Errorf("%s public key does not match private key", format)
+				}
+			}
+		})
+	}
+}
+
+func TestKeypairExists(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(out+openSSHPublicSuffix, []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Keypair(&KeypairArgs{Formats: []string{FormatPEM, FormatOpenSSH}, Out: out}); err == nil {
+		t.Fatal("expected error for existing public key file")
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Errorf("private key written despite error")
+	}
+}
+
+func TestKeypairAlgorithm(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "key")
+	if err := Keypair(&KeypairArgs{Formats: []string{FormatOpenSSH}, Out: out, Algorithm: AlgorithmRSA2048}); err == nil {
+		t.Fatal("expected error for OpenSSH format RSA key")
+	}
+	if err := Keypair(&KeypairArgs{Formats: []string{FormatPEM}, Out: out, Algorithm: AlgorithmRSA2048}); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := LoadPrivateKey(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		t.Errorf("got %T key, want RSA", signer.Public())
+	}
+}
+
+func TestWriteKeyFilesCleanup(t *testing.T) {
+	dir := t.TempDir()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := marshalPrivateKey(priv, FormatPEM, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The public key cannot be written to a missing directory.
+	paths := []string{filepath.Join(dir, "key"), filepath.Join(dir, "missing", "key"+pemPublicSuffix)}
+	if err := writeKeyFiles(paths, privateKey, pub, []string{FormatPEM}, "key"); err == nil {
+		t.Fatal("expected error for missing directory")
+	}
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("private key left behind: %v", err)
+	}
+}
@@ -0,0 +1,327 @@
+//go:build cgo
+
+package keygen
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"system-transparency.org/stboot/stlog"
+)
+
+// Constants from PKCS#11 v3.0, missing in the pkcs11 package.
+const (
+	ckkECEdwards = 0x40
+	ckmEdDSA     = 0x1057
+)
+
+// DigestInfo prefixes for PKCS #1 v1.5 signatures, see RFC 8017.
+var pkcs1Prefix = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pkcs11Signer is a crypto.Signer backed by an Ed25519 or RSA private
+// key on a PKCS#11 token. It owns the module context and the session,
+// which are released by Close.
+type pkcs11Signer struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	public  crypto.PublicKey
+
+	// Set if this signer initialized the module, or logged in,
+	// rather than some other user of the module in this process.
+	initialized, open, loggedIn bool
+}
+
+func loadPKCS11Key(uri string) (crypto.Signer, error) {
+	u, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := pkcs11.New(u.modulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 module %q", u.modulePath)
+	}
+	s := &pkcs11Signer{ctx: ctx}
+	signer, err := s.load(u)
+	if err != nil {
+		if closeErr := s.Close(); closeErr != nil {
+			stlog.Debug("Releasing PKCS#11 module: %v", closeErr)
+		}
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+func (s *pkcs11Signer) load(u *pkcs11URI) (crypto.Signer, error) {
+	err := s.ctx.Initialize()
+	switch {
+	case err == nil:
+		s.initialized = true
+	case !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED):
+		return nil, fmt.Errorf("initializing PKCS#11 module: %w", err)
+	}
+
+	slot, err := findSlot(s.ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("opening PKCS#11 session: %w", err)
+	}
+	s.open = true
+	if u.pin != "" {
+		err := s.ctx.Login(s.session, pkcs11.CKU_USER, u.pin)
+		switch {
+		case err == nil:
+			s.loggedIn = true
+		case !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN):
+			return nil, fmt.Errorf("PKCS#11 login: %w", err)
+		}
+	}
+
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY)}
+	if u.object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.object))
+	}
+	if u.id != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, u.id))
+	}
+	s.key, err = findObject(s.ctx, s.session, template)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+
+	s.public, err = publicKey(s.ctx, s.session, s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Close logs out, closes the session and finalizes and unloads the
+// module, as far as this signer set them up. The signer cannot be used
+// afterwards.
+func (s *pkcs11Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx == nil {
+		return nil
+	}
+
+	var errs []error
+	if s.loggedIn {
+		if err := s.ctx.Logout(s.session); err != nil {
+			errs = append(errs, fmt.Errorf("PKCS#11 logout: %w", err))
+		}
+	}
+	if s.open {
+		if err := s.ctx.CloseSession(s.session); err != nil {
+			errs = append(errs, fmt.Errorf("closing PKCS#11 session: %w", err))
+		}
+	}
+	if s.initialized {
+		if err := s.ctx.Finalize(); err != nil {
+			errs = append(errs, fmt.Errorf("finalizing PKCS#11 module: %w", err))
+		}
+	}
+	s.ctx.Destroy()
+	s.ctx = nil
+
+	return errors.Join(errs...)
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism *pkcs11.Mechanism
+	data := digest
+
+	switch s.public.(type) {
+	case ed25519.PublicKey:
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, errors.New("pre-hashed Ed25519 is not supported")
+		}
+		mechanism = pkcs11.NewMechanism(ckmEdDSA, nil)
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("RSA-PSS is not supported")
+		}
+		prefix, ok := pkcs1Prefix[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+		}
+		if len(digest) != opts.HashFunc().Size() {
+			return nil, errors.New("digest size does not match hash function")
+		}
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+		data = append(append([]byte{}, prefix...), digest...)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", s.public)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx == nil {
+		return nil, errors.New("PKCS#11 signer is closed")
+	}
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{mechanism}, s.key); err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign: %w", err)
+	}
+	sig, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign: %w", err)
+	}
+
+	return sig, nil
+}
+
+func findSlot(ctx *pkcs11.Ctx, u *pkcs11URI) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("listing PKCS#11 slots: %w", err)
+	}
+
+	for _, slot := range slots {
+		if u.slotID != nil && *u.slotID != slot {
+			continue
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("reading PKCS#11 token info: %w", err)
+		}
+		if matchTokenAttr(u.token, info.Label) && matchTokenAttr(u.manufacturer, info.ManufacturerID) &&
+			matchTokenAttr(u.serial, info.SerialNumber) && matchTokenAttr(u.model, info.Model) {
+			return slot, nil
+		}
+	}
+
+	return 0, errors.New("no matching PKCS#11 token found")
+}
+
+// Token info fields are padded with spaces.
+func matchTokenAttr(want, got string) bool {
+	return want == "" || want == strings.TrimRight(got, " \x00")
+}
+
+func findObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(objects) {
+	case 0:
+		return 0, errors.New("no matching object found")
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, errors.New("more than one matching object found")
+	}
+}
+
+// publicKey reads the public key corresponding to a private key, from
+// the public key object with the same id, or from the private key
+// object itself.
+func publicKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, key pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := ctx.GetAttributeValue(session, key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading private key attributes: %w", err)
+	}
+	keyType := bytesToUint(attrs[0].Value)
+
+	source := key
+	if pub, err := findObject(ctx, session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, attrs[1].Value),
+	}); err == nil {
+		source = pub
+	}
+
+	switch keyType {
+	case ckkECEdwards:
+		attrs, err := ctx.GetAttributeValue(session, source, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading Ed25519 public key: %w", err)
+		}
+		point := attrs[0].Value
+		// Usually DER encoded as an OCTET STRING, but some
+		// tokens return the raw point.
+		if len(point) != ed25519.PublicKeySize {
+			if _, err := asn1.Unmarshal(point, &point); err != nil {
+				return nil, fmt.Errorf("invalid Ed25519 public key: %w", err)
+			}
+		}
+		if len(point) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key size, is it an Ed448 key?")
+		}
+
+		return ed25519.PublicKey(point), nil
+	case pkcs11.CKK_RSA:
+		attrs, err := ctx.GetAttributeValue(session, source, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading RSA public key: %w", err)
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type %#x, only Ed25519 and RSA are supported", keyType)
+	}
+}
+
+// CK_ULONG attributes are returned in native byte order.
+func bytesToUint(b []byte) uint {
+	switch len(b) {
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	default:
+		return 0
+	}
+}
+
+func isPKCS11Error(err error, code uint) bool {
+	var p11Err pkcs11.Error
+
+	return errors.As(err, &p11Err) && uint(p11Err) == code
+}
@@ -0,0 +1,12 @@
+//go:build !cgo
+
+package keygen
+
+import (
+	"crypto"
+	"errors"
+)
+
+func loadPKCS11Key(_ string) (crypto.Signer, error) {
+	return nil, errors.New("PKCS#11 keys are not supported, stmgr was built without cgo")
+}
@@ -0,0 +1,147 @@
+package keygen
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const pkcs11Scheme = "pkcs11:"
+
+// pkcs11URI holds the attributes of a PKCS#11 URI (RFC 7512) that are
+// used to find a private key on a token.
+type pkcs11URI struct {
+	// Token attributes, empty if unset.
+	token        string
+	manufacturer string
+	serial       string
+	model        string
+	slotID       *uint
+
+	// Object attributes, empty if unset.
+	object string
+	id     []byte
+
+	modulePath string
+	pin        string
+}
+
+func isPKCS11URI(s string) bool {
+	return strings.HasPrefix(s, pkcs11Scheme)
+}
+
+// parsePKCS11URI parses a URI like
+//
+//	pkcs11:token=ca;object=root?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=pin.txt
+//
+// The module-path query attribute is required. The PIN is given by
+// either pin-value or pin-source, if neither is present, the token is
+// used without logging in.
+func parsePKCS11URI(uri string) (*pkcs11URI, error) {
+	if !isPKCS11URI(uri) {
+		return nil, fmt.Errorf("not a PKCS#11 URI: %q", uri)
+	}
+	path, query, _ := strings.Cut(strings.TrimPrefix(uri, pkcs11Scheme), "?")
+
+	var u pkcs11URI
+	var pinSource string
+
+	err := forEachPKCS11Attr(path, ";", func(name, value string) error {
+		switch name {
+		case "token":
+			u.token = value
+		case "manufacturer":
+			u.manufacturer = value
+		case "serial":
+			u.serial = value
+		case "model":
+			u.model = value
+		case "slot-id":
+			id, err := strconv.ParseUint(value, 10, 0)
+			if err != nil {
+				return fmt.Errorf("invalid slot-id %q", value)
+			}
+			slotID := uint(id)
+			u.slotID = &slotID
+		case "object":
+			u.object = value
+		case "id":
+			u.id = []byte(value)
+		case "type":
+			if value != "private" {
+				return fmt.Errorf("unsupported object type %q, expected private", value)
+			}
+		case "library-manufacturer", "library-description", "library-version", "slot-manufacturer",
+			"slot-description":
+			// Not needed to identify a key in practice.
+		default:
+			return fmt.Errorf("unsupported attribute %q", name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 URI: %w", err)
+	}
+
+	err = forEachPKCS11Attr(query, "&", func(name, value string) error {
+		switch name {
+		case "module-path":
+			u.modulePath = value
+		case "pin-value":
+			u.pin = value
+		case "pin-source":
+			pinSource = value
+		default:
+			return fmt.Errorf("unsupported query attribute %q", name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 URI: %w", err)
+	}
+
+	if u.modulePath == "" {
+		return nil, errors.New("invalid PKCS#11 URI: missing module-path")
+	}
+	if u.object == "" && u.id == nil {
+		return nil, errors.New("invalid PKCS#11 URI: missing object or id")
+	}
+	if pinSource != "" {
+		if u.pin != "" {
+			return nil, errors.New("invalid PKCS#11 URI: both pin-value and pin-source")
+		}
+		pin, err := os.ReadFile(strings.TrimPrefix(pinSource, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("reading PKCS#11 PIN: %w", err)
+		}
+		u.pin = strings.TrimRight(string(pin), "\r\n")
+	}
+
+	return &u, nil
+}
+
+func forEachPKCS11Attr(s, sep string, fn func(name, value string) error) error {
+	if s == "" {
+		return nil
+	}
+	for _, attr := range strings.Split(s, sep) {
+		name, value, ok := strings.Cut(attr, "=")
+		if !ok {
+			return fmt.Errorf("attribute %q without value", attr)
+		}
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return fmt.Errorf("attribute %q: %w", name, err)
+		}
+		if err := fn(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
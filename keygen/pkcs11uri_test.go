@@ -0,0 +1,60 @@
+package keygen
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePKCS11URI(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinFile, []byte("1234\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	slotID := uint(3)
+
+	for _, table := range []struct {
+		uri  string
+		want *pkcs11URI
+	}{
+		{
+			uri:  "pkcs11:token=ca;object=root?module-path=/usr/lib/softhsm/libsofthsm2.so",
+			want: &pkcs11URI{token: "ca", object: "root", modulePath: "/usr/lib/softhsm/libsofthsm2.so"},
+		},
+		{
+			uri: "pkcs11:token=My%20CA;id=%01%02;type=private;slot-id=3?module-path=/lib/p11.so&pin-value=0000",
+			want: &pkcs11URI{token: "My CA", id: []byte{1, 2}, slotID: &slotID,
+				modulePath: "/lib/p11.so", pin: "0000"},
+		},
+		{
+			uri:  "pkcs11:serial=42;object=k?pin-source=file:" + pinFile + "&module-path=/lib/p11.so",
+			want: &pkcs11URI{serial: "42", object: "k", modulePath: "/lib/p11.so", pin: "1234"},
+		},
+	} {
+		got, err := parsePKCS11URI(table.uri)
+		if err != nil {
+			t.Errorf("%q: %v", table.uri, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, table.want) {
+			t.Errorf("%q: got %+v, want %+v", table.uri, got, table.want)
+		}
+	}
+}
+
+func TestParsePKCS11URIInvalid(t *testing.T) {
+	for _, uri := range []string{
+		"key.pem",
+		"pkcs11:object=root",
+		"pkcs11:token=ca?module-path=/lib/p11.so",
+		"pkcs11:object=root;type=cert?module-path=/lib/p11.so",
+		"pkcs11:object=root;foo=bar?module-path=/lib/p11.so",
+		"pkcs11:object=root?module-path=/lib/p11.so&pin-value=1&pin-source=pin.txt",
+		"pkcs11:object=%zz?module-path=/lib/p11.so",
+	} {
+		if _, err := parsePKCS11URI(uri); err == nil {
+			t.Errorf("%q: expected error", uri)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package keygen
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const signerScheme = "signer:"
+
+// Operations of the external signer protocol, passed as the last
+// command line argument.
+const (
+	signerOpPublicKey = "public-key"
+	signerOpSign      = "sign"
+)
+
+// Hash names passed to the sign operation. With signerHashNone, the
+// input is the message itself (Ed25519), otherwise it is a digest
+// computed with the named hash function.
+var signerHashNames = map[crypto.Hash]string{
+	crypto.Hash(0): "none",
+	crypto.SHA256:  "sha256",
+	crypto.SHA384:  "sha384",
+	crypto.SHA512:  "sha512",
+}
+
+// programSigner is a crypto.Signer backed by an external program,
+// invoked once per operation:
+//
+//	PROGRAM [ARGS...] public-key
+//	PROGRAM [ARGS...] sign HASH
+//
+// For public-key, the program writes the public key in PEM or OpenSSH
+// format to stdout. For sign, the program reads the message (HASH is
+// "none") or digest (HASH is "sha256", "sha384" or "sha512") on
+// stdin, and writes the raw signature to stdout: 64 bytes for Ed25519,
+// PKCS #1 v1.5 for RSA, and ASN.1 DER for ECDSA. Anything the program
+// writes to stderr is passed on, and a non-zero exit status means
+// failure.
+type programSigner struct {
+	program string
+	args    []string
+	public  crypto.PublicKey
+}
+
+func isSignerSpec(s string) bool {
+	return strings.HasPrefix(s, signerScheme)
+}
+
+// parseSignerSpec parses a spec like signer:/path/to/program?arg1&arg2,
+// where the arguments are percent-encoded.
+func parseSignerSpec(spec string) (string, []string, error) {
+	if !isSignerSpec(spec) {
+		return "", nil, fmt.Errorf("not a signer spec: %q", spec)
+	}
+	program, query, hasQuery := strings.Cut(strings.TrimPrefix(spec, signerScheme), "?")
+	if program == "" {
+		return "", nil, errors.New("invalid signer spec: missing program")
+	}
+
+	var args []string
+	if hasQuery && query != "" {
+		for _, arg := range strings.Split(query, "&") {
+			arg, err := url.PathUnescape(arg)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid signer spec: %w", err)
+			}
+			args = append(args, arg)
+		}
+	}
+
+	return program, args, nil
+}
+
+func loadProgramSigner(spec string) (crypto.Signer, error) {
+	program, args, err := parseSignerSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	s := &programSigner{program: program, args: args}
+
+	out, err := s.run(nil, signerOpPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	s.public, err = parsePublicKey(out)
+	if err != nil {
+		return nil, fmt.Errorf("signer %s: invalid public key: %w", program, err)
+	}
+	switch s.public.(type) {
+	case ed25519.PublicKey, *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("signer %s: unsupported public key type %T", program, s.public)
+	}
+
+	return s, nil
+}
+
+func (s *programSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *programSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("RSA-PSS is not supported by external signers")
+	}
+	hashName, ok := signerHashNames[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+	}
+
+	sig, err := s.run(digest, signerOpSign, hashName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Catch misbehaving signers here, rather than when the signature
+	// is verified later on.
+	valid := false
+	switch pub := s.public.(type) {
+	case ed25519.PublicKey:
+		valid = opts.HashFunc() == crypto.Hash(0) && ed25519.Verify(pub, digest, sig)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(pub, opts.HashFunc(), digest, sig) == nil
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(pub, digest, sig)
+	}
+	if !valid {
+		return nil, fmt.Errorf("signer %s: invalid signature", s.program)
+	}
+
+	return sig, nil
+}
+
+func (s *programSigner) run(stdin []byte, op ...string) ([]byte, error) {
+	cmd := exec.Command(s.program, append(append([]string{}, s.args...), op...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("signer %s %s: %w", s.program, op[0], err)
+	}
+
+	return out, nil
+}
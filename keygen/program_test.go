@@ -0,0 +1,37 @@
+package keygen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSignerSpec(t *testing.T) {
+	for _, table := range []struct {
+		spec    string
+		program string
+		args    []string
+	}{
+		{spec: "signer:/usr/bin/kms-sign", program: "/usr/bin/kms-sign"},
+		{spec: "signer:kms-sign?", program: "kms-sign"},
+		{
+			spec:    "signer:/usr/bin/kms-sign?--key&projects%2Fst%2Fkeys%2Froot&a%26b",
+			program: "/usr/bin/kms-sign",
+			args:    []string{"--key", "projects/st/keys/root", "a&b"},
+		},
+	} {
+		program, args, err := parseSignerSpec(table.spec)
+		if err != nil {
+			t.Errorf("%q: %v", table.spec, err)
+			continue
+		}
+		if program != table.program || !reflect.DeepEqual(args, table.args) {
+			t.Errorf("%q: got %q %q, want %q %q", table.spec, program, args, table.program, table.args)
+		}
+	}
+
+	for _, spec := range []string{"key.pem", "signer:", "signer:?arg", "signer:prog?%zz"} {
+		if _, _, err := parseSignerSpec(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
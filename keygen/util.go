@@ -14,6 +14,7 @@ import (
 
 	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/key"
+	"system-transparency.org/stboot/stlog"
 )
 
 var (
@@ -43,8 +44,16 @@ func (s sigsumSigner) Public() crypto.PublicKey {
 }
 
 // Loads a private key file, either x509 style, or an OpenSSH public
-// key file where private key is accessed using ssh-agent.
+// key file where private key is accessed using ssh-agent. A pkcs11:
+// URI instead refers to a key on a PKCS#11 token, and a signer: spec
+// to a key accessed through an external signer program.
 func LoadPrivateKey(fileName string) (crypto.Signer, error) {
+	if isPKCS11URI(fileName) {
+		return loadPKCS11Key(fileName)
+	}
+	if isSignerSpec(fileName) {
+		return loadProgramSigner(fileName)
+	}
 	data, err := os.ReadFile(fileName)
 	if err != nil {
 		return nil, err
@@ -89,11 +98,35 @@ func LoadPrivateKey(fileName string) (crypto.Signer, error) {
 	}
 }
 
+// CloseSigner releases what a signer returned by LoadPrivateKey holds
+// on to, i.e., the session and module of a key on a PKCS#11 token.
+// Failures are only logged, as the signatures are made at that point.
+func CloseSigner(signer crypto.Signer) {
+	if c, ok := signer.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			stlog.Warn("Releasing private key: %v", err)
+		}
+	}
+}
+
 func LoadPublicKey(fileName string) (crypto.PublicKey, error) {
+	if isPKCS11URI(fileName) || isSignerSpec(fileName) {
+		signer, err := LoadPrivateKey(fileName)
+		if err != nil {
+			return nil, err
+		}
+		defer CloseSigner(signer)
+		return signer.Public(), nil
+	}
 	data, err := os.ReadFile(fileName)
 	if err != nil {
 		return nil, err
 	}
+	return parsePublicKey(data)
+}
+
+// Parses a public key in either OpenSSH or PKIX PEM format.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
 	if bytes.HasPrefix(data, []byte("ssh-ed25519 ")) {
 		// Attempt decoding as OpenSSH pubkey.
 		pub, err := key.ParsePublicKey(string(data))
@@ -138,6 +171,36 @@ func LoadCertBytes(path string) ([]byte, error) {
 	return block.Bytes, nil
 }
 
+// LoadCertChain loads one or more PEM coded x509 certificates, e.g., a
+// certificate followed by the intermediate certificates of its chain.
+func LoadCertChain(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain [][]byte
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("invalid cert file, got type %q", block.Type)
+		}
+		chain = append(chain, block.Bytes)
+		data = rest
+	}
+	if len(chain) == 0 {
+		return nil, ErrNoPEMBlock
+	}
+	if len(bytes.TrimSpace(data)) != 0 {
+		return nil, ErrTrailing
+	}
+
+	return chain, nil
+}
+
 // WritePEM writes the pem.Block data to a PEM formatted
 // file to the specified path.
 func WritePEM(block *pem.Block, path string) error {
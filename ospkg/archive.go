@@ -0,0 +1,208 @@
+package ospkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
+	ospkgs "system-transparency.org/stboot/ospkg"
+)
+
+const (
+	manifestName = "manifest.json"
+	// Permissions recorded for all entries of reproducible archives.
+	reproducibleFilePerm = 0o644
+)
+
+// The range of timestamps the zip format can represent, with its
+// seven bit year counted from 1980 and two second resolution.
+var (
+	zipEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+	zipEnd   = time.Date(2107, time.December, 31, 23, 59, 58, 0, time.UTC)
+)
+
+// loadedPackage is an OS package read into memory.
+type loadedPackage struct {
+	descriptor *ospkgs.Descriptor
+	manifest   *ospkgs.OSManifest
+	archive    *zip.Reader
+	hash       sigsumCrypto.Hash
+	size       int64
+}
+
+// loadPackage reads the archive and descriptor of an OS package, and
+// parses them with ospkgs.NewOSPackage, so that OS packages stboot
+// refuses are refused here too.
+func loadPackage(pkgPath string) (*loadedPackage, error) {
+	archive, err := os.ReadFile(pkgPath + ospkgs.OSPackageExt)
+	if err != nil {
+		return nil, err
+	}
+	descriptorBytes, err := os.ReadFile(pkgPath + ospkgs.DescriptorExt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ospkgs.NewOSPackage(archive, descriptorBytes); err != nil {
+		return nil, fmt.Errorf("invalid OS package %q: %w", pkgPath, err)
+	}
+
+	descriptor, err := ospkgs.DescriptorFromBytes(descriptorBytes)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	m, err := readManifest(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loadedPackage{
+		descriptor: descriptor,
+		manifest:   m,
+		archive:    zr,
+		hash:       sigsumCrypto.HashBytes(archive),
+		size:       int64(len(archive)),
+	}, nil
+}
+
+// readManifest parses the manifest of an OS package archive with
+// stboot's ospkg package, which points out the boot files within the
+// archive.
+func readManifest(zr *zip.Reader) (*ospkgs.OSManifest, error) {
+	f, err := findFile(zr, manifestName)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := ospkgs.OSManifestFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", manifestName, err)
+	}
+	if m.KernelPath == "" {
+		return nil, fmt.Errorf("invalid %s: missing kernel", manifestName)
+	}
+
+	return m, nil
+}
+
+func findFile(zr *zip.Reader, name string) (*zip.File, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+
+	return nil, fmt.Errorf("archive is missing %q", name)
+}
+
+// hashArchive computes the SHA-256 hash of the archive of an OS
+// package, which is what gets signed, reading it as a stream.
+func hashArchive(pkgPath string) (sigsumCrypto.Hash, error) {
+	f, err := os.Open(pkgPath + ospkgs.OSPackageExt)
+	if err != nil {
+		return sigsumCrypto.Hash{}, err
+	}
+	defer f.Close()
+
+	return sigsumCrypto.HashFile(f)
+}
+
+// checkPkgURL checks that the descriptor URL, if any, is one stboot
+// can fetch from.
+func checkPkgURL(pkgURL string) error {
+	if pkgURL == "" {
+		return nil
+	}
+	u, err := url.Parse(pkgURL)
+	if err != nil {
+		return fmt.Errorf("invalid OS package URL: %w", err)
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid OS package URL %q: unsupported scheme %q", pkgURL, u.Scheme)
+	}
+
+	return nil
+}
+
+// normalizeArchive rewrites the zip archive read from r, of the given
+// size, to w, so that the result only depends on the names and
+// contents of its entries. Entries are sorted by name, and
+// timestamps, permissions and extra fields are reset. The compressed
+// data is copied as is.
+func normalizeArchive(r io.ReaderAt, size int64, w io.Writer, modTime time.Time) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	modTime = modTime.UTC()
+	switch {
+	case modTime.Before(zipEpoch):
+		modTime = zipEpoch
+	case modTime.After(zipEnd):
+		modTime = zipEnd
+	}
+
+	files := make([]*zip.File, len(zr.File))
+	copy(files, zr.File)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	zw := zip.NewWriter(w)
+
+	for _, f := range files {
+		header := &zip.FileHeader{
+			Name:               f.Name,
+			Method:             f.Method,
+			CRC32:              f.CRC32,
+			CompressedSize64:   f.CompressedSize64,
+			UncompressedSize64: f.UncompressedSize64,
+		}
+		// CreateRaw uses the header as is, so set the MS-DOS
+		// date and time fields directly, rather than Modified.
+		header.ModifiedDate, header.ModifiedTime = msDosTime(modTime)
+		header.SetMode(reproducibleFilePerm)
+
+		fw, err := zw.CreateRaw(header)
+		if err != nil {
+			return err
+		}
+		fr, err := f.OpenRaw()
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fw, fr); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// msDosTime converts t to the MS-DOS date and time format used in zip
+// headers, which has a resolution of two seconds.
+func msDosTime(t time.Time) (uint16, uint16) {
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+
+	return date, clock
+}
@@ -1,21 +1,57 @@
 package ospkg
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"time"
 
 	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/initramfs"
+	"system-transparency.org/stmgr/kernel"
 )
 
-const defaultFilePerm fs.FileMode = 0o600
+const (
+	defaultFilePerm   fs.FileMode = 0o600
+	descriptorVersion             = 1
+)
 
 type CreateArgs struct {
-	OutPath   string
-	Label     string
-	URL       string
-	Kernel    string
-	Initramfs string
-	Cmdline   string
+	OutPath string
+	Label   string
+	URL     string
+	Kernel  string
+	Cmdline string
+
+	// Initramfs lists initramfs files, which are concatenated in
+	// order if there are several, e.g., a microcode update followed
+	// by the main initramfs.
+	Initramfs []string
+
+	// InitramfsDir, if set, is a directory tree to write as an
+	// initramfs, using InitramfsCompression, appended after
+	// Initramfs.
+	InitramfsDir         string
+	InitramfsCompression string
+
+	// FromUKI names a unified kernel image to take kernel,
+	// initramfs and cmdline from, instead of the fields above.
+	FromUKI string
+
+	// Reproducible normalises the archive, so that the same kernel,
+	// initramfs and cmdline always give a byte-identical archive.
+	// All entries get ModTime as timestamp.
+	Reproducible bool
+	ModTime      time.Time
+
+	// NoCheck skips checking that the kernel is a Linux kernel
+	// image, and linting the cmdline, see kernel.Check.
+	NoCheck bool
 }
 
 // Create will create a new OS package using the
@@ -28,23 +64,56 @@ func Create(args *CreateArgs) error {
 		return err
 	}
 
-	osp, err := ospkgs.CreateOSPackage(
-		args.Label,
-		args.URL,
-		args.Kernel,
-		args.Initramfs,
-		args.Cmdline,
-	)
+	dir, err := os.MkdirTemp("", "stmgr-ospkg-")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(dir)
 
-	archive, err := osp.ArchiveBytes()
+	if args.FromUKI != "" {
+		if err := writeUKIContents(args, dir); err != nil {
+			return err
+		}
+	}
+
+	if args.Kernel == "" {
+		return errors.New("missing kernel")
+	}
+	if err := checkPkgURL(args.URL); err != nil {
+		return err
+	}
+	if !args.NoCheck {
+		h, err := kernel.Check(args.Kernel, args.Cmdline)
+		if err != nil {
+			return fmt.Errorf("%w (use -no-check to create the OS package anyway)", err)
+		}
+		stlog.Info("Kernel: %s", h)
+		if h.Version != "" {
+			stlog.Info("Kernel version: %s", h.Version)
+		}
+	}
+
+	initramfsPath, err := buildInitramfs(args, dir)
 	if err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(args.OutPath+ospkgs.OSPackageExt, archive, defaultFilePerm); err != nil {
+	osp, err := ospkgs.CreateOSPackage(args.Label, args.URL, args.Kernel, initramfsPath, args.Cmdline)
+	if err != nil {
+		return err
+	}
+	archive, err := osp.ArchiveBytes()
+	if err != nil {
+		return err
+	}
+	if args.Reproducible {
+		normalized := new(bytes.Buffer)
+		if err := normalizeArchive(bytes.NewReader(archive), int64(len(archive)), normalized, args.ModTime); err != nil {
+			return err
+		}
+		archive = normalized.Bytes()
+	}
+	if err := writeFileAtomic(args.OutPath+ospkgs.OSPackageExt, archive, defaultFilePerm); err != nil {
 		return err
 	}
 
@@ -53,11 +122,65 @@ func Create(args *CreateArgs) error {
 		return err
 	}
 
-	if err := os.WriteFile(args.OutPath+ospkgs.DescriptorExt, descriptor, defaultFilePerm); err != nil {
+	return writeFileAtomic(args.OutPath+ospkgs.DescriptorExt, descriptor, defaultFilePerm)
+}
+
+// buildInitramfs returns the path of the initramfs to include, if
+// any. Unless there is a single initramfs file, the initramfs files
+// and the archive written from InitramfsDir are concatenated into a
+// new file in dir. Each part is padded to a multiple of four bytes, as
+// the kernel expects.
+func buildInitramfs(args *CreateArgs, dir string) (string, error) {
+	if args.InitramfsDir == "" {
+		switch len(args.Initramfs) {
+		case 0:
+			return "", nil
+		case 1:
+			return args.Initramfs[0], nil
+		}
+	}
+
+	path := filepath.Join(dir, "initramfs")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, defaultFilePerm)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for _, part := range args.Initramfs {
+		if err := appendInitramfs(f, part); err != nil {
+			return "", err
+		}
+	}
+
+	if args.InitramfsDir != "" {
+		compression := args.InitramfsCompression
+		if compression == "" {
+			compression = initramfs.CompressGzip
+		}
+		stlog.Debug("Writing initramfs from %q, compressed with %s", args.InitramfsDir, compression)
+		if err := initramfs.WriteDir(f, args.InitramfsDir, compression, args.ModTime); err != nil {
+			return "", fmt.Errorf("writing initramfs from %q: %w", args.InitramfsDir, err)
+		}
+	}
+
+	return path, f.Close()
+}
+
+func appendInitramfs(f *os.File, path string) error {
+	part, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	n, err := io.Copy(f, part)
+	if err != nil {
 		return err
 	}
+	_, err = f.Write(make([]byte, (4-n%4)%4))
 
-	return nil
+	return err
 }
 
 func checkArgs(args *CreateArgs) (*CreateArgs, error) {
@@ -0,0 +1,167 @@
+package ospkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stmgr/initramfs"
+)
+
+func TestCreateReproducible(t *testing.T) {
+	modTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	build := func(fileTime time.Time) []byte {
+		dir := t.TempDir()
+		kernel := filepath.Join(dir, "kernel")
+		initramfs := filepath.Join(dir, "initramfs")
+		for _, file := range []string{kernel, initramfs} {
+			if err := os.WriteFile(file, []byte("contents of "+filepath.Base(file)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(file, fileTime, fileTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out := filepath.Join(dir, "ospkg")
+		if err := Create(&CreateArgs{
+			OutPath:      out,
+			Kernel:       kernel,
+			Initramfs:    []string{initramfs},
+			Cmdline:      "console=ttyS0",
+			Reproducible: true,
+			ModTime:      modTime,
+			NoCheck:      true,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		archive, err := os.ReadFile(out + ospkgs.OSPackageExt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		descriptor, err := os.ReadFile(out + ospkgs.DescriptorExt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The normalized archive is still one stboot accepts.
+		if _, err := ospkgs.NewOSPackage(archive, descriptor); err != nil {
+			t.Fatal(err)
+		}
+
+		return archive
+	}
+
+	first := build(time.Now())
+	second := build(time.Now().Add(-time.Hour))
+
+	if !bytes.Equal(first, second) {
+		t.Fatalf("archives differ")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range zr.File {
+		if !f.Modified.Equal(modTime) {
+			t.Errorf("entry %q: got timestamp %v, want %v", f.Name, f.Modified, modTime)
+		}
+		if f.Mode().Perm() != reproducibleFilePerm {
+			t.Errorf("entry %q: got mode %v, want %v", f.Name, f.Mode(), os.FileMode(reproducibleFilePerm))
+		}
+		if i > 0 && zr.File[i-1].Name > f.Name {
+			t.Errorf("entries not sorted: %q before %q", zr.File[i-1].Name, f.Name)
+		}
+	}
+}
+
+func TestNormalizeArchiveTimeRange(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	if _, err := zw.Create("file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []struct {
+		modTime, want time.Time
+	}{
+		{modTime: time.Unix(0, 0), want: zipEpoch},
+		{modTime: time.Date(2200, time.January, 1, 0, 0, 0, 0, time.UTC), want: zipEnd},
+	} {
+		archive := new(bytes.Buffer)
+		if err := normalizeArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()), archive, table.modTime); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := zr.File[0].Modified; !got.Equal(table.want) {
+			t.Errorf("%v: got timestamp %v, want %v", table.modTime, got, table.want)
+		}
+	}
+}
+
+func TestBuildInitramfs(t *testing.T) {
+	dir := t.TempDir()
+	microcode := filepath.Join(dir, "microcode")
+	if err := os.WriteFile(microcode, []byte("ucode"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mainPart := filepath.Join(dir, "main.cpio")
+	var buf bytes.Buffer
+	if err := initramfs.WriteDir(&buf, t.TempDir(), initramfs.CompressNone, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mainPart, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "hostname"), []byte("st\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := buildInitramfs(&CreateArgs{
+		Initramfs:            []string{microcode, mainPart},
+		InitramfsDir:         root,
+		InitramfsCompression: initramfs.CompressZstd,
+	}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The microcode is padded to four bytes, the rest is cpio.
+	if want := []byte("ucode\x00\x00\x00"); !bytes.HasPrefix(got, want) {
+		t.Fatalf("initramfs starts with %q, want %q", got[:len(want)], want)
+	}
+	if !bytes.HasPrefix(got[8:], buf.Bytes()) {
+		t.Fatalf("second part of initramfs differs")
+	}
+	var names []string
+	if err := initramfs.Walk(bytes.NewReader(got[8:]), func(hdr *initramfs.Header, _ io.Reader) error {
+		names = append(names, hdr.Name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"etc", "etc/hostname"}; fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("got entries %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,394 @@
+package ospkg
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/initramfs"
+	"system-transparency.org/stmgr/internal/errwriter"
+	"system-transparency.org/stmgr/kernel"
+	"system-transparency.org/stmgr/keygen"
+)
+
+// PackageDiff lists the differences between two OS packages. Only
+// the parts that differ are set.
+type PackageDiff struct {
+	Old          string         `json:"old"`
+	New          string         `json:"new"`
+	Label        *StringChange  `json:"label,omitempty"`
+	URL          *StringChange  `json:"os_pkg_url,omitempty"`
+	Archive      *StringChange  `json:"archive_sha256,omitempty"`
+	Kernel       *KernelDiff    `json:"kernel,omitempty"`
+	Cmdline      *ListDiff      `json:"cmdline,omitempty"`
+	Initramfs    *InitramfsDiff `json:"initramfs,omitempty"`
+	Certificates *ListDiff      `json:"certificates,omitempty"`
+	Signatures   *ListDiff      `json:"signatures,omitempty"`
+}
+
+type StringChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type KernelDiff struct {
+	SHA256  StringChange  `json:"sha256"`
+	Version *StringChange `json:"version,omitempty"`
+}
+
+type ListDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+type InitramfsDiff struct {
+	SHA256  StringChange `json:"sha256"`
+	Added   []string     `json:"added,omitempty"`
+	Removed []string     `json:"removed,omitempty"`
+	Changed []string     `json:"changed,omitempty"`
+}
+
+// pkgContents is what Diff compares of each OS package.
+type pkgContents struct {
+	descriptor    *ospkgs.Descriptor
+	manifest      *ospkgs.OSManifest
+	archiveHash   string
+	kernelHash    string
+	kernelVersion string
+	initramfsHash string
+	// Files in the initramfs, nil if it could not be parsed.
+	initramfsFiles map[string]initramfsFile
+}
+
+type initramfsFile struct {
+	mode     uint32
+	uid, gid uint32
+	hash     [sha256.Size]byte
+}
+
+// Diff compares two OS packages, and writes the differences to out,
+// either human readable or as JSON.
+func Diff(oldPath, newPath string, jsonOutput bool, out io.Writer) error {
+	oldPkg, err := readPkgContents(oldPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", oldPath, err)
+	}
+	newPkg, err := readPkgContents(newPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", newPath, err)
+	}
+
+	diff := diffPackages(oldPkg, newPkg)
+	diff.Old, diff.New = oldPath, newPath
+
+	if jsonOutput {
+		pretty, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(pretty, '\n'))
+
+		return err
+	}
+
+	return diff.writeText(out)
+}
+
+func diffPackages(oldPkg, newPkg *pkgContents) *PackageDiff {
+	diff := &PackageDiff{
+		Label:   stringChange(oldPkg.manifest.Label, newPkg.manifest.Label),
+		URL:     stringChange(oldPkg.descriptor.PkgURL, newPkg.descriptor.PkgURL),
+		Archive: stringChange(oldPkg.archiveHash, newPkg.archiveHash),
+	}
+
+	if oldPkg.kernelHash != newPkg.kernelHash {
+		diff.Kernel = &KernelDiff{
+			SHA256:  StringChange{Old: oldPkg.kernelHash, New: newPkg.kernelHash},
+			Version: stringChange(oldPkg.kernelVersion, newPkg.kernelVersion),
+		}
+	}
+
+	// Unbalanced quotes are a problem of the package, not the diff.
+	oldCmdline, _ := kernel.SplitCmdline(oldPkg.manifest.Cmdline)
+	newCmdline, _ := kernel.SplitCmdline(newPkg.manifest.Cmdline)
+	diff.Cmdline = listDiff(oldCmdline, newCmdline)
+
+	if oldPkg.initramfsHash != newPkg.initramfsHash {
+		diff.Initramfs = &InitramfsDiff{
+			SHA256: StringChange{Old: oldPkg.initramfsHash, New: newPkg.initramfsHash},
+		}
+		if oldPkg.initramfsFiles != nil && newPkg.initramfsFiles != nil {
+			diff.Initramfs.Added, diff.Initramfs.Removed, diff.Initramfs.Changed =
+				diffFiles(oldPkg.initramfsFiles, newPkg.initramfsFiles)
+		}
+	}
+
+	diff.Certificates = listDiff(describeCerts(oldPkg.descriptor), describeCerts(newPkg.descriptor))
+	diff.Signatures = listDiff(describeSignatures(oldPkg.descriptor), describeSignatures(newPkg.descriptor))
+
+	return diff
+}
+
+func readPkgContents(pkgPath string) (*pkgContents, error) {
+	pkgPath, err := parsePkgPath(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := loadPackage(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &pkgContents{
+		descriptor:  pkg.descriptor,
+		manifest:    pkg.manifest,
+		archiveHash: hex.EncodeToString(pkg.hash[:]),
+	}
+
+	if err := contents.readKernel(pkg.archive); err != nil {
+		return nil, err
+	}
+	if pkg.manifest.InitramfsPath != "" {
+		if err := contents.readInitramfs(pkg.archive); err != nil {
+			return nil, err
+		}
+	}
+
+	return contents, nil
+}
+
+func (c *pkgContents) readKernel(zr *zip.Reader) error {
+	f, err := findFile(zr, c.manifest.KernelPath)
+	if err != nil {
+		return err
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	header := make([]byte, kernel.HeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	header = header[:n]
+
+	h := sha256.New()
+	h.Write(header)
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	c.kernelHash = hex.EncodeToString(h.Sum(nil))
+
+	if bzImage, err := kernel.ParseHeader(header); err == nil {
+		c.kernelVersion = bzImage.Version
+	}
+
+	return nil
+}
+
+func (c *pkgContents) readInitramfs(zr *zip.Reader) error {
+	f, err := findFile(zr, c.manifest.InitramfsPath)
+	if err != nil {
+		return err
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	files := make(map[string]initramfsFile)
+	walkErr := initramfs.Walk(io.TeeReader(r, h), func(hdr *initramfs.Header, contents io.Reader) error {
+		file := initramfsFile{mode: hdr.Mode, uid: hdr.UID, gid: hdr.GID}
+		fh := sha256.New()
+		if _, err := io.Copy(fh, contents); err != nil {
+			return err
+		}
+		copy(file.hash[:], fh.Sum(nil))
+		files[hdr.Name] = file
+
+		return nil
+	})
+	if walkErr != nil {
+		stlog.Warn("Cannot list files of initramfs %q: %v", c.manifest.InitramfsPath, walkErr)
+		files = nil
+	}
+
+	// Hash whatever the walk did not need to read.
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	c.initramfsHash = hex.EncodeToString(h.Sum(nil))
+	c.initramfsFiles = files
+
+	return nil
+}
+
+func describeCerts(descriptor *ospkgs.Descriptor) []string {
+	var certs []string
+	for _, certDER := range descriptor.Certificates {
+		certs = append(certs, describeCert(certDER))
+	}
+
+	return certs
+}
+
+func describeCert(certDER []byte) string {
+	fingerprint := sha256.Sum256(certDER)
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return fmt.Sprintf("invalid certificate %x", fingerprint)
+	}
+	keyHash, err := keygen.HashPublicKey(cert.PublicKey)
+	if err != nil {
+		keyHash = cert.PublicKeyAlgorithm.String()
+	}
+
+	return fmt.Sprintf("%s, key %s, fingerprint %x", cert.Subject, keyHash, fingerprint)
+}
+
+func describeSignatures(descriptor *ospkgs.Descriptor) []string {
+	var sigs []string
+	for i, sig := range descriptor.Signatures {
+		signer := "unknown"
+		if i < len(descriptor.Certificates) {
+			if cert, err := x509.ParseCertificate(descriptor.Certificates[i]); err == nil {
+				signer = cert.Subject.String()
+			}
+		}
+		sigs = append(sigs, fmt.Sprintf("%s signature %x by %s", signatureKind(sig), sha256.Sum256(sig), signer))
+	}
+
+	return sigs
+}
+
+func stringChange(oldValue, newValue string) *StringChange {
+	if oldValue == newValue {
+		return nil
+	}
+
+	return &StringChange{Old: oldValue, New: newValue}
+}
+
+// listDiff compares two lists as multisets, returns nil if they are
+// equal.
+func listDiff(oldList, newList []string) *ListDiff {
+	count := make(map[string]int)
+	for _, s := range oldList {
+		count[s]--
+	}
+	for _, s := range newList {
+		count[s]++
+	}
+
+	diff := &ListDiff{}
+	// Iterate over the lists rather than the map, to keep the order.
+	for _, s := range oldList {
+		if count[s] < 0 {
+			diff.Removed = append(diff.Removed, s)
+			count[s]++
+		}
+	}
+	for _, s := range newList {
+		if count[s] > 0 {
+			diff.Added = append(diff.Added, s)
+			count[s]--
+		}
+	}
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+		return nil
+	}
+
+	return diff
+}
+
+func diffFiles(oldFiles, newFiles map[string]initramfsFile) ([]string, []string, []string) {
+	var added, removed, changed []string
+
+	for name, oldFile := range oldFiles {
+		newFile, ok := newFiles[name]
+		switch {
+		case !ok:
+			removed = append(removed, name)
+		case newFile != oldFile:
+			changed = append(changed, name)
+		}
+	}
+	for name := range newFiles {
+		if _, ok := oldFiles[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	return added, removed, changed
+}
+
+func (diff *PackageDiff) writeText(out io.Writer) error {
+	w := &errwriter.Writer{W: out}
+
+	writeChange := func(what string, change *StringChange) {
+		if change != nil {
+			w.Printf("%s:\n  - %s\n  + %s\n", what, change.Old, change.New)
+		}
+	}
+	writeList := func(what string, diff *ListDiff) {
+		if diff == nil {
+			return
+		}
+		w.Printf("%s:\n", what)
+		for _, s := range diff.Removed {
+			w.Printf("  - %s\n", s)
+		}
+		for _, s := range diff.Added {
+			w.Printf("  + %s\n", s)
+		}
+	}
+
+	writeChange("Label", diff.Label)
+	writeChange("URL", diff.URL)
+	writeChange("Archive SHA-256", diff.Archive)
+	if diff.Kernel != nil {
+		writeChange("Kernel SHA-256", &diff.Kernel.SHA256)
+		writeChange("Kernel version", diff.Kernel.Version)
+	}
+	writeList("Cmdline", diff.Cmdline)
+	if diff.Initramfs != nil {
+		writeChange("Initramfs SHA-256", &diff.Initramfs.SHA256)
+		if n := len(diff.Initramfs.Added) + len(diff.Initramfs.Removed) + len(diff.Initramfs.Changed); n > 0 {
+			w.Printf("Initramfs files:\n")
+		}
+		for _, name := range diff.Initramfs.Removed {
+			w.Printf("  - %s\n", name)
+		}
+		for _, name := range diff.Initramfs.Added {
+			w.Printf("  + %s\n", name)
+		}
+		for _, name := range diff.Initramfs.Changed {
+			w.Printf("  ~ %s\n", name)
+		}
+	}
+	writeList("Certificates", diff.Certificates)
+	writeList("Signatures", diff.Signatures)
+
+	if diff.Archive == nil && diff.URL == nil && diff.Certificates == nil && diff.Signatures == nil {
+		w.Printf("OS packages are identical\n")
+	}
+
+	return w.Err
+}
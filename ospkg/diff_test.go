@@ -0,0 +1,62 @@
+package ospkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListDiff(t *testing.T) {
+	for _, table := range []struct {
+		name     string
+		old, new []string
+		want     *ListDiff
+	}{
+		{
+			name: "equal",
+			old:  []string{"console=ttyS0", "quiet"},
+			new:  []string{"quiet", "console=ttyS0"},
+		},
+		{
+			name: "changed",
+			old:  []string{"console=ttyS0", "quiet"},
+			new:  []string{"console=tty0", "quiet", "debug"},
+			want: &ListDiff{Added: []string{"console=tty0", "debug"}, Removed: []string{"console=ttyS0"}},
+		},
+		{
+			name: "duplicates",
+			old:  []string{"a", "a", "b"},
+			new:  []string{"a", "b", "b"},
+			want: &ListDiff{Added: []string{"b"}, Removed: []string{"a"}},
+		},
+	} {
+		t.Run(table.name, func(t *testing.T) {
+			if got := listDiff(table.old, table.new); !reflect.DeepEqual(got, table.want) {
+				t.Errorf("listDiff = %+v, want %+v", got, table.want)
+			}
+		})
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	oldFiles := map[string]initramfsFile{
+		"init":    {mode: 0o100755, hash: [32]byte{1}},
+		"bin/sh":  {mode: 0o120777, hash: [32]byte{2}},
+		"etc/foo": {mode: 0o100644, hash: [32]byte{3}},
+	}
+	newFiles := map[string]initramfsFile{
+		"init":    {mode: 0o100755, hash: [32]byte{4}},
+		"bin/sh":  {mode: 0o120777, hash: [32]byte{2}},
+		"etc/bar": {mode: 0o100644, hash: [32]byte{3}},
+	}
+
+	added, removed, changed := diffFiles(oldFiles, newFiles)
+	if want := []string{"etc/bar"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %q, want %q", added, want)
+	}
+	if want := []string{"etc/foo"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %q, want %q", removed, want)
+	}
+	if want := []string{"init"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %q, want %q", changed, want)
+	}
+}
@@ -0,0 +1,177 @@
+package ospkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/proof"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/stlog"
+)
+
+const (
+	extractKernelName    = "kernel"
+	extractInitramfsName = "initramfs"
+	extractCmdlineName   = "cmdline"
+)
+
+// ExtractArgs is a list of arguments
+// that's passed to Extract().
+type ExtractArgs struct {
+	PkgPath        string
+	OutDir         string
+	TrustPolicyDir string // Optional, verify the OS package before extracting.
+}
+
+// Extract writes the kernel, initramfs and kernel command line of an
+// OS package to separate files in the output directory. Before that,
+// all signatures in the descriptor are checked to be made over the
+// archive, and optionally, the OS package is verified using a Trust
+// policy directory. Files from an earlier extraction are replaced.
+func Extract(args *ExtractArgs) error {
+	if args.OutDir == "" {
+		return errors.New("missing output directory")
+	}
+
+	pkgPath, err := parsePkgPath(args.PkgPath)
+	if err != nil {
+		return err
+	}
+
+	if args.TrustPolicyDir != "" {
+		if err := VerifyTrustPolicy(args.TrustPolicyDir, &VerifyArgs{PkgPath: args.PkgPath}); err != nil {
+			return fmt.Errorf("refusing to extract: %w", err)
+		}
+	}
+
+	pkg, err := loadPackage(pkgPath)
+	if err != nil {
+		return err
+	}
+	if err := checkSignedHash(pkg.descriptor, &pkg.hash); err != nil {
+		return fmt.Errorf("archive does not match descriptor: %w", err)
+	}
+	m := pkg.manifest
+
+	if err := os.MkdirAll(args.OutDir, 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary directory first, and move the files into
+	// place once all are written, so that a failure leaves no
+	// partial files behind.
+	tmpDir, err := os.MkdirTemp(args.OutDir, ".extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	names := []string{extractKernelName, extractCmdlineName}
+	if err := extractFile(pkg.archive, m.KernelPath, filepath.Join(tmpDir, extractKernelName)); err != nil {
+		return err
+	}
+	if m.InitramfsPath != "" {
+		if err := extractFile(pkg.archive, m.InitramfsPath, filepath.Join(tmpDir, extractInitramfsName)); err != nil {
+			return err
+		}
+		names = append(names, extractInitramfsName)
+	} else if err := os.Remove(filepath.Join(args.OutDir, extractInitramfsName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		// Don't leave the initramfs of an earlier extraction.
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, extractCmdlineName), []byte(m.Cmdline), defaultFilePerm); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(tmpDir, name), filepath.Join(args.OutDir, name)); err != nil {
+			return err
+		}
+	}
+
+	stlog.Info("Extracted OS package %q to %q", pkgPath, args.OutDir)
+
+	return nil
+}
+
+// checkSignedHash checks that each signature in the descriptor is made
+// over the given archive hash by the key of the corresponding
+// certificate. Certificate chains and Sigsum cosignatures are not
+// examined, that is what verification against a trust policy is for.
+func checkSignedHash(descriptor *ospkgs.Descriptor, archiveHash *crypto.Hash) error {
+	if len(descriptor.Certificates) != len(descriptor.Signatures) {
+		return fmt.Errorf("descriptor has %d certificates but %d signatures",
+			len(descriptor.Certificates), len(descriptor.Signatures))
+	}
+	if len(descriptor.Signatures) == 0 {
+		stlog.Warn("Descriptor has no signatures, archive hash cannot be checked")
+	}
+
+	for i, sig := range descriptor.Signatures {
+		if err := checkSignedBy(descriptor.Certificates[i], sig, archiveHash); err != nil {
+			return fmt.Errorf("signature %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// checkSignedBy checks that sig, either a plain Ed25519 signature or
+// a Sigsum proof, is made over the archive hash by the key of the
+// certificate.
+func checkSignedBy(certDER, sig []byte, archiveHash *crypto.Hash) error {
+	publicKey, err := ed25519PublicKeyFromCert(certDER)
+	if err != nil {
+		return fmt.Errorf("certificate: %w", err)
+	}
+
+	switch signatureKind(sig) {
+	case SignatureKindEd25519:
+		if !ed25519.Verify(publicKey[:], archiveHash[:], sig) {
+			return errors.New("invalid signature")
+		}
+	case SignatureKindSigsum:
+		var sigsumProof proof.SigsumProof
+		if err := sigsumProof.FromASCII(bytes.NewReader(sig)); err != nil {
+			return err
+		}
+
+		return verifyLeaf(&sigsumProof, &publicKey, archiveHash)
+	default:
+		return errors.New("unknown signature format")
+	}
+
+	return nil
+}
+
+func extractFile(zr *zip.Reader, name, out string) error {
+	f, err := findFile(zr, name)
+	if err != nil {
+		return err
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, defaultFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return fmt.Errorf("extracting %q: %w", name, err)
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,103 @@
+package ospkg
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"system-transparency.org/stboot/host"
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/trust"
+)
+
+// Values of ospkg_fetch_method in the trust policy.
+const (
+	fetchFromNetwork   = "network"
+	fetchFromInitramfs = "initramfs"
+)
+
+var ErrPkgURL = errors.New("OS package URL does not match trust policy")
+
+// checkFetchMethod checks the descriptor URL against how stboot will
+// fetch the OS package. With network fetch, stboot downloads the
+// archive from the descriptor URL, so it must be a well-formed https
+// URL naming the archive. With initramfs fetch, the URL is unused,
+// which is returned as a warning, since it is likely a mistake.
+func checkFetchMethod(trustPolicy *trust.Policy, descriptor *ospkgs.Descriptor, pkgPath string) (string, error) {
+	archiveName := filepath.Base(pkgPath) + ospkgs.OSPackageExt
+
+	switch string(trustPolicy.FetchMethod) {
+	case fetchFromNetwork:
+		if descriptor.PkgURL == "" {
+			return "", fmt.Errorf("%w: network fetch, but descriptor has no URL", ErrPkgURL)
+		}
+		u, err := url.Parse(descriptor.PkgURL)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrPkgURL, err)
+		}
+		if u.Scheme != "https" || u.Host == "" {
+			return "", fmt.Errorf("%w: network fetch needs an https URL, got %q", ErrPkgURL, descriptor.PkgURL)
+		}
+		if name := path.Base(u.Path); name != archiveName {
+			return "", fmt.Errorf("%w: URL %q names %q, but the archive is %q",
+				ErrPkgURL, descriptor.PkgURL, name, archiveName)
+		}
+	case fetchFromInitramfs:
+		if descriptor.PkgURL != "" {
+			return fmt.Sprintf("initramfs fetch, descriptor URL %q is not used", descriptor.PkgURL), nil
+		}
+	}
+
+	return "", nil
+}
+
+// checkHostConfig checks that the OS package pointer of the host
+// configuration at hostConfigPath points at the OS package at pkgPath.
+// With network fetch, the pointer is a comma separated list of
+// descriptor URLs, one of which must name the descriptor. With
+// initramfs fetch, it names the OS package within the initramfs.
+func checkHostConfig(hostConfigPath string, trustPolicy *trust.Policy, pkgPath string) error {
+	data, err := os.ReadFile(hostConfigPath)
+	if err != nil {
+		return err
+	}
+	var cfg host.Config
+	if err := cfg.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("invalid host configuration %q: %w", hostConfigPath, err)
+	}
+	if cfg.OSPkgPointer == nil || *cfg.OSPkgPointer == "" {
+		return fmt.Errorf("host configuration %q has no ospkg_pointer", hostConfigPath)
+	}
+	pointer := *cfg.OSPkgPointer
+
+	pkgName := filepath.Base(pkgPath)
+	switch string(trustPolicy.FetchMethod) {
+	case fetchFromNetwork:
+		descriptorName := pkgName + ospkgs.DescriptorExt
+		for _, pkgURL := range strings.Split(pointer, ",") {
+			u, err := url.Parse(strings.TrimSpace(pkgURL))
+			if err != nil {
+				return fmt.Errorf("invalid ospkg_pointer URL %q: %w", pkgURL, err)
+			}
+			if path.Base(u.Path) == descriptorName {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("ospkg_pointer %q does not point at descriptor %q", pointer, descriptorName)
+	case fetchFromInitramfs:
+		name := path.Base(pointer)
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ospkgs.DescriptorExt), ospkgs.OSPackageExt)
+		if name != pkgName {
+			return fmt.Errorf("ospkg_pointer %q does not point at OS package %q", pointer, pkgName)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("cannot check ospkg_pointer with fetch method %q", trustPolicy.FetchMethod)
+	}
+}
@@ -0,0 +1,84 @@
+package ospkg
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/trust"
+)
+
+func testTrustPolicy(t *testing.T, fetchMethod string) *trust.Policy {
+	t.Helper()
+
+	var policy trust.Policy
+	if err := json.Unmarshal([]byte(`{"ospkg_signature_threshold": 1, "ospkg_fetch_method": "`+fetchMethod+`"}`), &policy); err != nil {
+		t.Fatal(err)
+	}
+
+	return &policy
+}
+
+func TestCheckFetchMethod(t *testing.T) {
+	for _, table := range []struct {
+		method  string
+		url     string
+		fail    bool
+		warning bool
+	}{
+		{method: fetchFromNetwork, url: "https://example.org/pkgs/os.zip"},
+		{method: fetchFromNetwork, url: "https://example.org/os.zip?id=1"},
+		{method: fetchFromNetwork, url: "", fail: true},
+		{method: fetchFromNetwork, url: "http://example.org/os.zip", fail: true},
+		{method: fetchFromNetwork, url: "https:///os.zip", fail: true},
+		{method: fetchFromNetwork, url: "https://example.org/other.zip", fail: true},
+		{method: fetchFromNetwork, url: "os.zip", fail: true},
+		{method: fetchFromInitramfs, url: ""},
+		{method: fetchFromInitramfs, url: "https://example.org/os.zip", warning: true},
+	} {
+		policy := testTrustPolicy(t, table.method)
+		descriptor := &ospkgs.Descriptor{Version: descriptorVersion, PkgURL: table.url}
+
+		warning, err := checkFetchMethod(policy, descriptor, "dir/os")
+		if got := err != nil; got != table.fail {
+			t.Errorf("%s %q: got error %v, want failure: %v", table.method, table.url, err, table.fail)
+		}
+		if err != nil && !errors.Is(err, ErrPkgURL) {
+			t.Errorf("%s %q: unexpected error %v", table.method, table.url, err)
+		}
+		if got := warning != ""; got != table.warning {
+			t.Errorf("%s %q: got warning %q, want warning: %v", table.method, table.url, warning, table.warning)
+		}
+	}
+}
+
+func TestCheckHostConfig(t *testing.T) {
+	hostConfig := filepath.Join(t.TempDir(), "host.json")
+
+	for _, table := range []struct {
+		method  string
+		pointer string
+		fail    bool
+	}{
+		{method: fetchFromNetwork, pointer: "https://a.example.org/os.json"},
+		{method: fetchFromNetwork, pointer: "https://a.example.org/other.json, https://b.example.org/os.json"},
+		{method: fetchFromNetwork, pointer: "https://a.example.org/os.zip", fail: true},
+		{method: fetchFromNetwork, pointer: "", fail: true},
+		{method: fetchFromInitramfs, pointer: "os"},
+		{method: fetchFromInitramfs, pointer: "/ospkg/os.json"},
+		{method: fetchFromInitramfs, pointer: "other", fail: true},
+	} {
+		if err := os.WriteFile(hostConfig, []byte(`{"ospkg_pointer": "`+table.pointer+`"}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		policy := testTrustPolicy(t, table.method)
+
+		err := checkHostConfig(hostConfig, policy, "dir/os")
+		if got := err != nil; got != table.fail {
+			t.Errorf("%s %q: got error %v, want failure: %v", table.method, table.pointer, err, table.fail)
+		}
+	}
+}
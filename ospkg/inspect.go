@@ -0,0 +1,239 @@
+package ospkg
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/proof"
+
+	"system-transparency.org/stmgr/internal/errwriter"
+	"system-transparency.org/stmgr/keygen"
+)
+
+const (
+	SignatureKindEd25519 = "ed25519"
+	SignatureKindSigsum  = "sigsum"
+	SignatureKindUnknown = "unknown"
+)
+
+// Inspection describes the contents of an OS package, as reported by
+// Inspect.
+type Inspection struct {
+	Descriptor DescriptorInfo `json:"descriptor"`
+	Archive    ArchiveInfo    `json:"archive"`
+}
+
+type DescriptorInfo struct {
+	Version    int             `json:"version"`
+	URL        string          `json:"os_pkg_url"`
+	Signatures []SignatureInfo `json:"signatures"`
+}
+
+type SignatureInfo struct {
+	Kind        string          `json:"kind"`
+	Certificate CertificateInfo `json:"certificate"`
+}
+
+type CertificateInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	KeyType   string    `json:"key_type"`
+	KeyHash   string    `json:"key_hash,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+type ArchiveInfo struct {
+	SHA256          string      `json:"sha256"`
+	Size            int64       `json:"size"`
+	ManifestVersion int         `json:"manifest_version"`
+	Label           string      `json:"label"`
+	Cmdline         string      `json:"cmdline"`
+	Kernel          EntryInfo   `json:"kernel"`
+	Initramfs       *EntryInfo  `json:"initramfs,omitempty"`
+	Entries         []EntryInfo `json:"entries"`
+}
+
+type EntryInfo struct {
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+}
+
+// Inspect writes a summary of the OS package at pkgPath to out, either
+// human readable or as JSON.
+func Inspect(pkgPath string, jsonOutput bool, out io.Writer) error {
+	info, err := inspect(pkgPath)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		pretty, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = out.Write(append(pretty, '\n'))
+
+		return err
+	}
+
+	return info.writeText(out)
+}
+
+func inspect(pkgPath string) (*Inspection, error) {
+	pkgPath, err := parsePkgPath(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := loadPackage(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	descriptor := pkg.descriptor
+
+	info := &Inspection{
+		Descriptor: DescriptorInfo{
+			Version:    descriptor.Version,
+			URL:        descriptor.PkgURL,
+			Signatures: []SignatureInfo{},
+		},
+	}
+
+	if len(descriptor.Certificates) != len(descriptor.Signatures) {
+		return nil, fmt.Errorf("descriptor has %d certificates but %d signatures",
+			len(descriptor.Certificates), len(descriptor.Signatures))
+	}
+
+	for i, certDER := range descriptor.Certificates {
+		certInfo, err := inspectCert(certDER)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", i, err)
+		}
+		info.Descriptor.Signatures = append(info.Descriptor.Signatures, SignatureInfo{
+			Kind:        signatureKind(descriptor.Signatures[i]),
+			Certificate: *certInfo,
+		})
+	}
+
+	archive, err := inspectArchive(pkg)
+	if err != nil {
+		return nil, err
+	}
+	info.Archive = *archive
+
+	return info, nil
+}
+
+func inspectArchive(pkg *loadedPackage) (*ArchiveInfo, error) {
+	m := pkg.manifest
+	info := &ArchiveInfo{
+		SHA256:          hex.EncodeToString(pkg.hash[:]),
+		Size:            pkg.size,
+		ManifestVersion: m.Version,
+		Label:           m.Label,
+		Cmdline:         m.Cmdline,
+		Entries:         []EntryInfo{},
+	}
+
+	for _, file := range pkg.archive.File {
+		entry := EntryInfo{Path: file.Name, Size: file.UncompressedSize64}
+		info.Entries = append(info.Entries, entry)
+
+		if file.Name == m.KernelPath {
+			info.Kernel = entry
+		}
+		if file.Name == m.InitramfsPath {
+			info.Initramfs = &entry
+		}
+	}
+
+	if info.Kernel.Path == "" {
+		return nil, fmt.Errorf("archive is missing kernel %q", m.KernelPath)
+	}
+	if m.InitramfsPath != "" && info.Initramfs == nil {
+		return nil, fmt.Errorf("archive is missing initramfs %q", m.InitramfsPath)
+	}
+
+	return info, nil
+}
+
+func inspectCert(certDER []byte) (*CertificateInfo, error) {
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &CertificateInfo{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		KeyType:   cert.PublicKeyAlgorithm.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+	if keyHash, err := keygen.HashPublicKey(cert.PublicKey); err == nil {
+		info.KeyHash = keyHash
+	}
+
+	return info, nil
+}
+
+// signatureKind tells plain Ed25519 signatures apart from Sigsum
+// proofs of logging, the two kinds of signatures stboot accepts.
+func signatureKind(sig []byte) string {
+	if len(sig) == ed25519.SignatureSize {
+		return SignatureKindEd25519
+	}
+
+	var sigsumProof proof.SigsumProof
+	if err := sigsumProof.FromASCII(bytes.NewReader(sig)); err == nil {
+		return SignatureKindSigsum
+	}
+
+	return SignatureKindUnknown
+}
+
+func (info *Inspection) writeText(out io.Writer) error {
+	w := &errwriter.Writer{W: out}
+
+	w.Printf("Descriptor:\n")
+	w.Printf("  Version:    %d\n", info.Descriptor.Version)
+	w.Printf("  URL:        %s\n", info.Descriptor.URL)
+	w.Printf("  Signatures: %d\n", len(info.Descriptor.Signatures))
+	for i, sig := range info.Descriptor.Signatures {
+		cert := sig.Certificate
+		w.Printf("  [%d] %s signature\n", i, sig.Kind)
+		w.Printf("      Subject:  %s\n", cert.Subject)
+		w.Printf("      Issuer:   %s\n", cert.Issuer)
+		w.Printf("      Key type: %s\n", cert.KeyType)
+		if cert.KeyHash != "" {
+			w.Printf("      Key hash: %s\n", cert.KeyHash)
+		}
+		w.Printf("      Valid:    %s to %s\n",
+			cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	w.Printf("Archive:\n")
+	w.Printf("  SHA-256:    %s\n", info.Archive.SHA256)
+	w.Printf("  Size:       %d\n", info.Archive.Size)
+	w.Printf("  Manifest:   version %d\n", info.Archive.ManifestVersion)
+	w.Printf("  Label:      %s\n", info.Archive.Label)
+	w.Printf("  Kernel:     %s (%d bytes)\n", info.Archive.Kernel.Path, info.Archive.Kernel.Size)
+	if info.Archive.Initramfs != nil {
+		w.Printf("  Initramfs:  %s (%d bytes)\n", info.Archive.Initramfs.Path, info.Archive.Initramfs.Size)
+	}
+	w.Printf("  Cmdline:    %s\n", info.Archive.Cmdline)
+	w.Printf("  Entries:\n")
+	for _, entry := range info.Archive.Entries {
+		w.Printf("    %10d  %s\n", entry.Size, entry.Path)
+	}
+
+	return w.Err
+}
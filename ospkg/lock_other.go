@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package ospkg
+
+import (
+	"os"
+
+	"system-transparency.org/stboot/stlog"
+)
+
+// lockFile is a no-op where flock is unavailable; concurrent updates
+// of the same descriptor are then not serialized.
+func lockFile(_ *os.File) error {
+	stlog.Debug("Advisory file locks not supported on this platform")
+	return nil
+}
@@ -0,0 +1,313 @@
+package ospkg
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/proof"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/trust"
+	"system-transparency.org/stmgr/internal/errwriter"
+	"system-transparency.org/stmgr/keygen"
+)
+
+const (
+	ReportText = "text"
+	ReportJSON = "json"
+)
+
+// VerifyReport lists the outcome of verifying each certificate and
+// signature pair of an OS package descriptor on its own.
+type VerifyReport struct {
+	Package         string            `json:"ospkg"`
+	ArchiveHash     string            `json:"archive_sha256"`
+	Time            time.Time         `json:"time"`
+	Threshold       int               `json:"threshold"`
+	ValidSignatures int               `json:"valid_signatures"`
+	Verified        bool              `json:"verified"`
+	Error           string            `json:"error,omitempty"`
+	ValidFrom       *time.Time        `json:"valid_from,omitempty"`
+	ValidUntil      *time.Time        `json:"valid_until,omitempty"`
+	Signatures      []SignatureReport `json:"signatures"`
+	Warnings        []string          `json:"warnings,omitempty"`
+
+	verifyErr error
+}
+
+// fail marks the report as failed, keeping the first error.
+func (report *VerifyReport) fail(err error) {
+	if !report.Verified {
+		return
+	}
+	report.Verified = false
+	report.verifyErr = err
+	report.Error = err.Error()
+}
+
+type SignatureReport struct {
+	Kind    string   `json:"kind"`
+	Subject string   `json:"subject,omitempty"`
+	KeyHash string   `json:"key_hash,omitempty"`
+	Valid   bool     `json:"valid"`
+	Reason  string   `json:"reason,omitempty"`
+	Chain   []string `json:"chain,omitempty"`
+	Counted bool     `json:"counted"`
+
+	// Intersection of the validity periods of all certificates in
+	// the chain.
+	ValidFrom  *time.Time `json:"valid_from,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+// newVerifyReport checks each certificate and signature pair the way
+// stboot does, but records the result of every pair instead of
+// stopping at the overall verdict.
+func newVerifyReport(descriptor *ospkgs.Descriptor, rootCerts *x509.CertPool, sigsumPolicy *policy.Policy,
+	trustPolicy *trust.Policy, hash *sigsumCrypto.Hash, now time.Time,
+) *VerifyReport {
+	report := &VerifyReport{
+		ArchiveHash: hex.EncodeToString(hash[:]),
+		Time:        now,
+		Threshold:   trustPolicy.SignatureThreshold,
+		Signatures:  []SignatureReport{},
+	}
+	if report.Threshold == ospkgs.SignatureThresholdAll {
+		report.Threshold = len(descriptor.Signatures)
+	}
+
+	// Each key is counted at most once towards the threshold.
+	seen := make(map[string]int)
+
+	for i, certDER := range descriptor.Certificates {
+		var sig []byte
+		if i < len(descriptor.Signatures) {
+			sig = descriptor.Signatures[i]
+		}
+		sr := checkSignature(certDER, sig, rootCerts, sigsumPolicy, hash, now)
+
+		if sr.Valid {
+			if first, ok := seen[sr.KeyHash]; ok {
+				sr.Reason = fmt.Sprintf("duplicate key, already counted for signature %d", first)
+			} else {
+				seen[sr.KeyHash] = i
+				sr.Counted = true
+				report.ValidSignatures++
+			}
+		}
+		report.Signatures = append(report.Signatures, *sr)
+	}
+	report.setValidity()
+
+	return report
+}
+
+// setValidity computes the period during which enough of the counted
+// signatures stay valid to meet the threshold, i.e., the span of time
+// in which at least threshold of the chain validity periods overlap.
+// Of several such spans, the one containing the report time is used,
+// or else the earliest one.
+func (report *VerifyReport) setValidity() {
+	type edge struct {
+		at    time.Time
+		delta int
+	}
+	var edges []edge
+	for _, sr := range report.Signatures {
+		if sr.Counted {
+			edges = append(edges, edge{*sr.ValidFrom, 1}, edge{*sr.ValidUntil, -1})
+		}
+	}
+
+	k := report.Threshold
+	if k < 1 {
+		k = 1
+	}
+
+	// Periods that only touch still overlap, so starts go first.
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at.Equal(edges[j].at) {
+			return edges[i].delta > edges[j].delta
+		}
+		return edges[i].at.Before(edges[j].at)
+	})
+
+	var from time.Time
+	count := 0
+	for _, e := range edges {
+		count += e.delta
+		switch {
+		case e.delta > 0 && count == k:
+			from = e.at
+		case e.delta < 0 && count == k-1:
+			if report.ValidFrom == nil || !report.Time.Before(from) && !report.Time.After(e.at) {
+				from, until := from, e.at
+				report.ValidFrom, report.ValidUntil = &from, &until
+			}
+		}
+	}
+}
+
+func checkSignature(certDER, sig []byte, rootCerts *x509.CertPool, sigsumPolicy *policy.Policy,
+	hash *sigsumCrypto.Hash, now time.Time,
+) *SignatureReport {
+	sr := &SignatureReport{Kind: signatureKind(sig)}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		sr.Reason = fmt.Sprintf("invalid certificate: %v", err)
+		return sr
+	}
+	sr.Subject = cert.Subject.String()
+
+	publicKey, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		sr.Reason = fmt.Sprintf("invalid public key type: %T", cert.PublicKey)
+		return sr
+	}
+	// Cannot fail for Ed25519 keys.
+	sr.KeyHash, _ = keygen.HashPublicKey(publicKey)
+
+	switch {
+	case now.Before(cert.NotBefore):
+		sr.Reason = fmt.Sprintf("certificate not valid until %s", cert.NotBefore.UTC().Format(time.RFC3339))
+		return sr
+	case now.After(cert.NotAfter):
+		sr.Reason = fmt.Sprintf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+		return sr
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:       rootCerts,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		sr.Reason = fmt.Sprintf("certificate does not chain to root certificate(s): %v", err)
+		if errors.As(err, new(x509.UnknownAuthorityError)) {
+			// Descriptors cannot hold intermediates, see
+			// loadSigningCert.
+			sr.Reason += "; a certificate issued by an intermediate CA needs that CA among the root certificates"
+		}
+		return sr
+	}
+	validFrom, validUntil := cert.NotBefore, cert.NotAfter
+	for _, c := range chains[0] {
+		sr.Chain = append(sr.Chain, c.Subject.String())
+		if c.NotBefore.After(validFrom) {
+			validFrom = c.NotBefore
+		}
+		if c.NotAfter.Before(validUntil) {
+			validUntil = c.NotAfter
+		}
+	}
+	sr.ValidFrom, sr.ValidUntil = &validFrom, &validUntil
+
+	switch sr.Kind {
+	case SignatureKindEd25519:
+		if !ed25519.Verify(publicKey, hash[:], sig) {
+			sr.Reason = "invalid Ed25519 signature"
+			return sr
+		}
+	case SignatureKindSigsum:
+		if sigsumPolicy == nil {
+			sr.Reason = "Sigsum proof, but no Sigsum policy available"
+			return sr
+		}
+		var sigsumProof proof.SigsumProof
+		if err := sigsumProof.FromASCII(bytes.NewReader(sig)); err != nil {
+			sr.Reason = fmt.Sprintf("invalid Sigsum proof: %v", err)
+			return sr
+		}
+		var submitKey sigsumCrypto.PublicKey
+		copy(submitKey[:], publicKey)
+		submitKeys := map[sigsumCrypto.Hash]sigsumCrypto.PublicKey{
+			sigsumCrypto.HashBytes(submitKey[:]): submitKey,
+		}
+		if err := sigsumProof.Verify(hash, submitKeys, sigsumPolicy); err != nil {
+			sr.Reason = fmt.Sprintf("invalid Sigsum proof: %v", err)
+			return sr
+		}
+	default:
+		sr.Reason = "unknown signature format"
+		return sr
+	}
+	sr.Valid = true
+
+	return sr
+}
+
+func (report *VerifyReport) write(format string, out io.Writer) error {
+	switch format {
+	case ReportJSON:
+		pretty, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(pretty, '\n'))
+
+		return err
+	case ReportText:
+		return report.writeText(out)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+func (report *VerifyReport) writeText(out io.Writer) error {
+	w := &errwriter.Writer{W: out}
+
+	w.Printf("OS package:  %s\n", report.Package)
+	w.Printf("Archive:     %s\n", report.ArchiveHash)
+	w.Printf("Time:        %s\n", report.Time.UTC().Format(time.RFC3339))
+	for i, sr := range report.Signatures {
+		result := "invalid"
+		if sr.Valid {
+			result = "valid"
+		}
+		counted := ""
+		if sr.Counted {
+			counted = ", counted"
+		}
+		w.Printf("[%d] %s signature: %s%s\n", i, sr.Kind, result, counted)
+		if sr.Subject != "" {
+			w.Printf("    Subject: %s\n", sr.Subject)
+		}
+		if len(sr.Chain) > 0 {
+			w.Printf("    Chain:   %s\n", strings.Join(sr.Chain, " -> "))
+		}
+		if sr.ValidFrom != nil {
+			w.Printf("    Valid:   %s to %s\n",
+				sr.ValidFrom.UTC().Format(time.RFC3339), sr.ValidUntil.UTC().Format(time.RFC3339))
+		}
+		if sr.Reason != "" {
+			w.Printf("    Reason:  %s\n", sr.Reason)
+		}
+	}
+	w.Printf("Threshold:   %d valid signatures required, %d found\n", report.Threshold, report.ValidSignatures)
+	if report.ValidFrom != nil {
+		w.Printf("Verifiable:  %s to %s\n",
+			report.ValidFrom.UTC().Format(time.RFC3339), report.ValidUntil.UTC().Format(time.RFC3339))
+	}
+	for _, warning := range report.Warnings {
+		w.Printf("Warning:     %s\n", warning)
+	}
+	if report.Verified {
+		w.Printf("Result:      verified\n")
+	} else {
+		w.Printf("Result:      failed: %s\n", report.Error)
+	}
+
+	return w.Err
+}
@@ -0,0 +1,198 @@
+package ospkg
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/trust"
+)
+
+func newTestCert(t testing.TB, pub crypto.PublicKey, parent *x509.Certificate, parentKey ed25519.PrivateKey,
+	notBefore, notAfter time.Time,
+) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+	}
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestNewVerifyReport(t *testing.T) {
+	now := time.Now()
+	hash := sigsumCrypto.HashBytes([]byte("archive"))
+
+	rootPub, rootKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newTestCert(t, rootPub, nil, rootKey, now.Add(-time.Hour), now.Add(time.Hour))
+	otherPub, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCert(t, otherPub, nil, otherKey, now.Add(-time.Hour), now.Add(time.Hour))
+
+	leafPub, leafKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestCert(t, leafPub, root, rootKey, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := newTestCert(t, leafPub, root, rootKey, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	foreign := newTestCert(t, leafPub, other, otherKey, now.Add(-time.Hour), now.Add(time.Hour))
+
+	sig := ed25519.Sign(leafKey, hash[:])
+	badSig := ed25519.Sign(leafKey, []byte("other"))
+
+	descriptor := &ospkgs.Descriptor{
+		Version: 1,
+		Certificates: [][]byte{
+			leaf.Raw, expired.Raw, foreign.Raw, leaf.Raw, leaf.Raw,
+		},
+		Signatures: [][]byte{
+			sig, sig, sig, badSig, sig,
+		},
+	}
+
+	rootCerts := x509.NewCertPool()
+	rootCerts.AddCert(root)
+
+	report := newVerifyReport(descriptor, rootCerts, nil, &trust.Policy{SignatureThreshold: 1}, &hash, now)
+
+	if got, want := report.ValidSignatures, 1; got != want {
+		t.Errorf("got %d valid signatures, want %d", got, want)
+	}
+	if got, want := report.Threshold, 1; got != want {
+		t.Errorf("got threshold %d, want %d", got, want)
+	}
+
+	for i, want := range []struct {
+		valid   bool
+		counted bool
+		reason  string
+	}{
+		{valid: true, counted: true},
+		{reason: "certificate expired"},
+		{reason: "does not chain"},
+		{reason: "invalid Ed25519 signature"},
+		{valid: true, reason: "duplicate key"},
+	} {
+		got := report.Signatures[i]
+		if got.Valid != want.valid || got.Counted != want.counted || !strings.Contains(got.Reason, want.reason) {
+			t.Errorf("signature %d: got valid %v, counted %v, reason %q; want %v, %v, %q",
+				i, got.Valid, got.Counted, got.Reason, want.valid, want.counted, want.reason)
+		}
+		if want.reason == "" && got.Reason != "" {
+			t.Errorf("signature %d: unexpected reason %q", i, got.Reason)
+		}
+	}
+	if got := report.Signatures[0].Chain; len(got) != 2 {
+		t.Errorf("got chain %q, want leaf and root", got)
+	}
+}
+
+func TestVerifyReportValidity(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) *time.Time {
+		t := base.Add(time.Duration(hours) * time.Hour)
+		return &t
+	}
+
+	signatures := []SignatureReport{
+		{Counted: true, ValidFrom: at(-3), ValidUntil: at(1)},
+		{Counted: true, ValidFrom: at(-1), ValidUntil: at(3)},
+		{Counted: true, ValidFrom: at(-2), ValidUntil: at(2)},
+		{Counted: false},
+	}
+
+	for _, table := range []struct {
+		threshold   int
+		from, until *time.Time
+	}{
+		{threshold: 1, from: at(-3), until: at(3)},
+		{threshold: 2, from: at(-2), until: at(2)},
+		{threshold: 3, from: at(-1), until: at(1)},
+		{threshold: 4},
+	} {
+		report := VerifyReport{Threshold: table.threshold, Signatures: signatures}
+		report.setValidity()
+
+		if table.from == nil {
+			if report.ValidFrom != nil || report.ValidUntil != nil {
+				t.Errorf("threshold %d: got window %v to %v, want none", table.threshold, report.ValidFrom, report.ValidUntil)
+			}
+			continue
+		}
+		if report.ValidFrom == nil || !report.ValidFrom.Equal(*table.from) ||
+			report.ValidUntil == nil || !report.ValidUntil.Equal(*table.until) {
+			t.Errorf("threshold %d: got window %v to %v, want %v to %v",
+				table.threshold, report.ValidFrom, report.ValidUntil, table.from, table.until)
+		}
+	}
+}
+
+func TestVerifyReportValidityDisjoint(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) *time.Time {
+		t := base.Add(time.Duration(hours) * time.Hour)
+		return &t
+	}
+
+	signatures := []SignatureReport{
+		{Counted: true, ValidFrom: at(0), ValidUntil: at(1)},
+		{Counted: true, ValidFrom: at(2), ValidUntil: at(3)},
+	}
+
+	for _, table := range []struct {
+		threshold   int
+		time        time.Time
+		from, until *time.Time
+	}{
+		{threshold: 1, time: *at(0), from: at(0), until: at(1)},
+		{threshold: 1, time: *at(2), from: at(2), until: at(3)},
+		{threshold: 1, time: *at(5), from: at(0), until: at(1)},
+		{threshold: 2, time: *at(0)},
+	} {
+		report := VerifyReport{Threshold: table.threshold, Time: table.time, Signatures: signatures}
+		report.setValidity()
+
+		if table.from == nil {
+			if report.ValidFrom != nil || report.ValidUntil != nil {
+				t.Errorf("threshold %d: got window %v to %v, want none", table.threshold, report.ValidFrom, report.ValidUntil)
+			}
+			continue
+		}
+		if report.ValidFrom == nil || !report.ValidFrom.Equal(*table.from) ||
+			report.ValidUntil == nil || !report.ValidUntil.Equal(*table.until) {
+			t.Errorf("threshold %d at %v: got window %v to %v, want %v to %v",
+				table.threshold, table.time, report.ValidFrom, report.ValidUntil, table.from, table.until)
+		}
+	}
+}
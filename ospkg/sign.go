@@ -1,61 +1,197 @@
 package ospkg
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 
 	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/stlog"
 	"system-transparency.org/stmgr/keygen"
 )
 
-var ErrInvalidSuffix = errors.New("invalid file extension")
+var (
+	ErrInvalidSuffix = errors.New("invalid file extension")
+	ErrIntermediates = errors.New("OS package descriptors cannot hold intermediate certificates")
+)
 
 const DefaultOutName = "system-transparency-os-package"
 
-// Sign will sign an OS package using the provided path
-// of the private ed25519 key and corresponding certificate.
-func Sign(keyPath, certPath, pkgPath string) error {
+// loadSigningCert loads the certificate to add to a descriptor along
+// with a signature. The descriptor has exactly one certificate per
+// signature, and stboot verifies it directly against the root
+// certificates of its trust policy, so there is no way to include
+// intermediate certificates. A chain bundle is refused, rather than
+// having verification fail at boot.
+func loadSigningCert(path string) ([]byte, error) {
+	chain, err := keygen.LoadCertChain(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) > 1 {
+		return nil, fmt.Errorf("%s: %w: stboot verifies the signing certificate directly against"+
+			" ospkg_signing_root.pem, so pass the signing certificate alone, and add any intermediate CA"+
+			" certificate to ospkg_signing_root.pem", path, ErrIntermediates)
+	}
+
+	return chain[0], nil
+}
+
+// Sign will sign an OS package using the provided paths
+// of private ed25519 keys and corresponding certificates. All
+// signatures are added at once, and the descriptor is only
+// written if all of them succeed. Keys that have already signed
+// the OS package are refused. The descriptor is locked while signing,
+// so that signers running at the same time do not lose signatures.
+func Sign(keyPaths, certPaths []string, pkgPath string) error {
+	if len(keyPaths) == 0 {
+		return errors.New("no signing key provided")
+	}
+	if len(keyPaths) != len(certPaths) {
+		return fmt.Errorf("got %d keys but %d certificates", len(keyPaths), len(certPaths))
+	}
+
 	pkgPath, err := parsePkgPath(pkgPath)
 	if err != nil {
 		return err
 	}
 
-	archive, err := os.ReadFile(pkgPath + ospkgs.OSPackageExt)
+	// Only the archive hash is needed, so the archive is never
+	// loaded into memory.
+	archiveHash, err := hashArchive(pkgPath)
 	if err != nil {
 		return err
 	}
 
-	descriptor, err := os.ReadFile(pkgPath + ospkgs.DescriptorExt)
+	return updateDescriptor(pkgPath, &archiveHash, func(descriptor *ospkgs.Descriptor) error {
+		signed := signedKeys(descriptor)
+
+		for i, keyPath := range keyPaths {
+			signer, err := keygen.LoadPrivateKey(keyPath)
+			if err != nil {
+				return err
+			}
+			defer keygen.CloseSigner(signer)
+			cert, err := loadSigningCert(certPaths[i])
+			if err != nil {
+				return err
+			}
+			keyHash, err := certKeyHash(cert, signer.Public())
+			if err != nil {
+				return fmt.Errorf("%s: %w", certPaths[i], err)
+			}
+			if _, ok := signed[keyHash]; ok {
+				return fmt.Errorf("key %s has already signed the OS package", keyHash)
+			}
+			signed[keyHash] = struct{}{}
+			publicKey, err := ed25519PublicKeyFromCert(cert)
+			if err != nil {
+				return fmt.Errorf("%s: %w", certPaths[i], err)
+			}
+
+			sig, err := signer.Sign(rand.Reader, archiveHash[:], crypto.Hash(0))
+			if err != nil {
+				return err
+			}
+			if !ed25519.Verify(publicKey[:], archiveHash[:], sig) {
+				return fmt.Errorf("signature by key %s does not verify", keyHash)
+			}
+			if err := descriptor.AddSignature(cert, sig); err != nil {
+				return err
+			}
+			stlog.Debug("Signed with key %s", keyHash)
+		}
+
+		return nil
+	})
+}
+
+// signedKeys returns the hashes of the keys of all certificates in the
+// descriptor.
+func signedKeys(descriptor *ospkgs.Descriptor) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, certDER := range descriptor.Certificates {
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			continue
+		}
+		if keyHash, err := ed25519KeyHash(cert.PublicKey); err == nil {
+			keys[keyHash] = struct{}{}
+		}
+	}
+
+	return keys
+}
+
+// checkNotSigned refuses a second signature by the same key, which
+// stboot would only count once towards the threshold.
+func checkNotSigned(descriptor *ospkgs.Descriptor, keyHash string) error {
+	if _, ok := signedKeys(descriptor)[keyHash]; ok {
+		return fmt.Errorf("key %s has already signed the OS package", keyHash)
+	}
+
+	return nil
+}
+
+// certKeyHash checks that the certificate is for the given public key,
+// and returns the key's hash.
+func certKeyHash(certDER []byte, publicKey crypto.PublicKey) (string, error) {
+	cert, err := x509.ParseCertificate(certDER)
 	if err != nil {
-		return err
+		return "", err
 	}
+	if key, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !key.Equal(publicKey) {
+		return "", errors.New("certificate does not match private key")
+	}
+
+	return ed25519KeyHash(cert.PublicKey)
+}
 
-	osp, err := ospkgs.NewOSPackage(archive, descriptor)
+// ed25519KeyHash returns the hash of an Ed25519 public key. Only
+// Ed25519 keys can sign OS packages, although keygen.HashPublicKey
+// hashes other keys as well.
+func ed25519KeyHash(pub crypto.PublicKey) (string, error) {
+	if _, ok := pub.(ed25519.PublicKey); !ok {
+		return "", fmt.Errorf("invalid public key type: %T", pub)
+	}
+
+	return keygen.HashPublicKey(pub)
+}
+
+// writeFileAtomic writes data to a temporary file in the same
+// directory, and renames it into place once it is safely on disk, so
+// that readers see either the old or the new contents.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 
-	signer, err := keygen.LoadPrivateKey(keyPath)
-	if err != nil {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
 		return err
 	}
-	cert, err := keygen.LoadCertBytes(certPath)
-	if err != nil {
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
 		return err
 	}
-	if err := osp.Sign(signer, cert); err != nil {
+	if err := f.Sync(); err != nil {
+		f.Close()
 		return err
 	}
-
-	signedDescriptor, err := osp.DescriptorBytes()
-	if err != nil {
+	if err := f.Close(); err != nil {
 		return err
 	}
 
-	return os.WriteFile(pkgPath+ospkgs.DescriptorExt, signedDescriptor, defaultFilePerm)
+	return os.Rename(f.Name(), path)
 }
 
 func parsePkgPath(path string) (string, error) {
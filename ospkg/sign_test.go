@@ -0,0 +1,196 @@
+package ospkg
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+)
+
+// Archive sizes used by the benchmarks. Allocations per operation
+// should not grow with the size, since archives are only streamed.
+var benchmarkSizes = []int64{16 << 20, 128 << 20}
+
+// writeRandomFile writes size bytes of incompressible data to path.
+func writeRandomFile(b *testing.B, path string, size int64) {
+	b.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.CopyN(f, mathrand.New(mathrand.NewSource(1)), size); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// writeTestKey writes a new Ed25519 key and a self-signed certificate
+// for it to dir, and returns their paths.
+func writeTestKey(tb testing.TB, dir, name string) (string, string) {
+	tb.Helper()
+
+	now := time.Now()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cert := newTestCert(tb, pub, nil, priv, now.Add(-time.Hour), now.Add(time.Hour))
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	keyPath := filepath.Join(dir, name+".key")
+	certPath := filepath.Join(dir, name+".cert")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		tb.Fatal(err)
+	}
+
+	return keyPath, certPath
+}
+
+// writeTestPackage writes an archive with the given contents and an
+// unsigned descriptor.
+func writeTestPackage(tb testing.TB, pkgPath string, archive []byte) {
+	tb.Helper()
+
+	if err := os.WriteFile(pkgPath+ospkgs.OSPackageExt, archive, 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	if err := writeDescriptor(pkgPath, &ospkgs.Descriptor{Version: descriptorVersion}); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestSignConcurrent(t *testing.T) {
+	const signers = 20
+
+	dir := t.TempDir()
+	pkgPath := filepath.Join(dir, "ospkg")
+	writeTestPackage(t, pkgPath, []byte("archive"))
+
+	keyPaths := make([]string, signers)
+	certPaths := make([]string, signers)
+	for i := range keyPaths {
+		keyPaths[i], certPaths[i] = writeTestKey(t, dir, fmt.Sprintf("signer%d", i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, signers)
+	for i := range keyPaths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- Sign(keyPaths[i:i+1], certPaths[i:i+1], pkgPath)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	descriptor, err := readDescriptor(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(descriptor.Signatures); got != signers {
+		t.Errorf("got %d signatures, want %d", got, signers)
+	}
+}
+
+func TestUpdateDescriptorArchiveChanged(t *testing.T) {
+	pkgPath := filepath.Join(t.TempDir(), "ospkg")
+	writeTestPackage(t, pkgPath, []byte("archive"))
+
+	hash, err := hashArchive(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateDescriptor(pkgPath, &hash, func(descriptor *ospkgs.Descriptor) error {
+		if err := os.WriteFile(pkgPath+ospkgs.OSPackageExt, []byte("other archive"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		return descriptor.AddSignature([]byte("cert"), []byte("sig"))
+	})
+	if !errors.Is(err, ErrArchiveChanged) {
+		t.Fatalf("got error %v, want %v", err, ErrArchiveChanged)
+	}
+
+	descriptor, err := readDescriptor(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descriptor.Signatures) != 0 {
+		t.Errorf("descriptor was updated")
+	}
+}
+
+func BenchmarkSign(b *testing.B) {
+	keyPath, certPath := writeTestKey(b, b.TempDir(), "signer")
+
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			pkgPath := filepath.Join(b.TempDir(), "ospkg")
+			writeRandomFile(b, pkgPath+ospkgs.OSPackageExt, size)
+
+			b.ReportAllocs()
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := writeDescriptor(pkgPath, &ospkgs.Descriptor{Version: descriptorVersion}); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if err := Sign([]string{keyPath}, []string{certPath}, pkgPath); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSignChainBundle(t *testing.T) {
+	dir := t.TempDir()
+	pkgPath := filepath.Join(dir, "ospkg")
+	writeTestPackage(t, pkgPath, []byte("archive"))
+	keyPath, certPath := writeTestKey(t, dir, "signer")
+	_, otherCertPath := writeTestKey(t, dir, "other")
+
+	cert, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := os.ReadFile(otherCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundlePath := filepath.Join(dir, "signer.chain")
+	if err := os.WriteFile(bundlePath, append(cert, other...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Sign([]string{keyPath}, []string{bundlePath}, pkgPath); !errors.Is(err, ErrIntermediates) {
+		t.Errorf("got err %v, want %v", err, ErrIntermediates)
+	}
+}
@@ -0,0 +1,355 @@
+package ospkg
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
+	"system-transparency.org/stboot/opts"
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/internal/errwriter"
+	"system-transparency.org/stmgr/keygen"
+)
+
+var (
+	ErrNoSignatureMatch = errors.New("no matching signature")
+	ErrArchiveChanged   = errors.New("OS package archive changed")
+
+	// Returned by the update in PruneSignatures, to leave the
+	// descriptor untouched.
+	errNothingToPrune = errors.New("nothing to prune")
+)
+
+// ListedSignature describes one certificate and signature pair of a
+// descriptor, as listed by ListSignatures.
+type ListedSignature struct {
+	Index       int              `json:"index"`
+	Kind        string           `json:"kind"`
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// RemoveArgs selects the signatures to remove with RemoveSignatures.
+type RemoveArgs struct {
+	PkgPath string
+	Index   int    // Index of the signature to remove, negative if unused.
+	KeyHash string // Remove all signatures by this key, empty if unused.
+}
+
+// PruneArgs is a list of arguments that's passed to PruneSignatures().
+type PruneArgs struct {
+	PkgPath string
+	// If set, signatures with certificates not chaining to any
+	// of these roots are removed as well.
+	RootCertsPath string
+	At            time.Time // Point in time to prune as of, zero means now.
+}
+
+// ListSignatures writes the signatures of an OS package descriptor to
+// out, either human readable or as JSON.
+func ListSignatures(pkgPath string, jsonOutput bool, out io.Writer) error {
+	pkgPath, err := parsePkgPath(pkgPath)
+	if err != nil {
+		return err
+	}
+	descriptor, err := readDescriptor(pkgPath)
+	if err != nil {
+		return err
+	}
+
+	list := []ListedSignature{}
+	for i, certDER := range descriptor.Certificates {
+		listed := ListedSignature{Index: i, Kind: signatureKind(descriptor.Signatures[i])}
+		if info, err := inspectCert(certDER); err != nil {
+			listed.Error = err.Error()
+		} else {
+			listed.Certificate = info
+		}
+		list = append(list, listed)
+	}
+
+	if jsonOutput {
+		pretty, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(pretty, '\n'))
+
+		return err
+	}
+
+	w := &errwriter.Writer{W: out}
+	for _, listed := range list {
+		if listed.Certificate == nil {
+			w.Printf("[%d] %s signature, invalid certificate: %s\n", listed.Index, listed.Kind, listed.Error)
+			continue
+		}
+		cert := listed.Certificate
+		w.Printf("[%d] %s signature\n", listed.Index, listed.Kind)
+		w.Printf("    Subject:  %s\n", cert.Subject)
+		if cert.KeyHash != "" {
+			w.Printf("    Key hash: %s\n", cert.KeyHash)
+		}
+		w.Printf("    Valid:    %s to %s\n",
+			cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	return w.Err
+}
+
+// RemoveSignatures removes the selected certificate and signature
+// pairs from an OS package descriptor.
+func RemoveSignatures(args *RemoveArgs) error {
+	if (args.Index < 0) == (args.KeyHash == "") {
+		return errors.New("exactly one of index and key hash must be provided")
+	}
+
+	pkgPath, err := parsePkgPath(args.PkgPath)
+	if err != nil {
+		return err
+	}
+
+	return updateDescriptor(pkgPath, nil, func(descriptor *ospkgs.Descriptor) error {
+		removed := filterSignatures(descriptor, func(i int, cert *x509.Certificate) string {
+			if i == args.Index {
+				return "by index"
+			}
+			if args.KeyHash != "" && cert != nil {
+				if keyHash, err := keygen.HashPublicKey(cert.PublicKey); err == nil && keyHash == args.KeyHash {
+					return "by key hash"
+				}
+			}
+
+			return ""
+		})
+		if removed == 0 {
+			return ErrNoSignatureMatch
+		}
+
+		return nil
+	})
+}
+
+// PruneSignatures removes certificate and signature pairs that can
+// no longer count towards the signature threshold: those with invalid
+// or expired certificates, those not chaining to the given root
+// certificates, those not valid for the archive, and all but one
+// signature by the same key. Of the valid signatures by the same key,
+// the one whose certificate expires last is kept.
+func PruneSignatures(args *PruneArgs) error {
+	now := args.At
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	pkgPath, err := parsePkgPath(args.PkgPath)
+	if err != nil {
+		return err
+	}
+
+	var rootCerts *x509.CertPool
+	if args.RootCertsPath != "" {
+		if rootCerts, err = opts.ReadCertsFile(args.RootCertsPath, now); err != nil {
+			return err
+		}
+	}
+
+	archiveHash, err := hashArchive(pkgPath)
+	if err != nil {
+		return err
+	}
+
+	err = updateDescriptor(pkgPath, &archiveHash, func(descriptor *ospkgs.Descriptor) error {
+		if removed := pruneSignatures(descriptor, &archiveHash, rootCerts, now); removed == 0 {
+			return errNothingToPrune
+		}
+
+		return nil
+	})
+	if errors.Is(err, errNothingToPrune) {
+		stlog.Info("Nothing to prune")
+		return nil
+	}
+
+	return err
+}
+
+func pruneSignatures(descriptor *ospkgs.Descriptor, archiveHash *sigsumCrypto.Hash, rootCerts *x509.CertPool,
+	now time.Time,
+) int {
+	reasons := make([]string, len(descriptor.Certificates))
+	kept := make(map[string]int)  // Key hash to index of the signature kept.
+	var certs []*x509.Certificate // Parsed certificates, by index.
+
+	for i, certDER := range descriptor.Certificates {
+		cert, err := x509.ParseCertificate(certDER)
+		certs = append(certs, cert)
+		switch {
+		case err != nil:
+			reasons[i] = "invalid certificate"
+			continue
+		case now.After(cert.NotAfter):
+			reasons[i] = fmt.Sprintf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+			continue
+		}
+		if rootCerts != nil {
+			if _, err := cert.Verify(x509.VerifyOptions{
+				Roots:       rootCerts,
+				CurrentTime: now,
+				KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			}); err != nil {
+				reasons[i] = "certificate does not chain to root certificate(s)"
+				continue
+			}
+		}
+		keyHash, err := ed25519KeyHash(cert.PublicKey)
+		if err != nil {
+			reasons[i] = err.Error()
+			continue
+		}
+		if err := checkSignedBy(certDER, descriptor.Signatures[i], archiveHash); err != nil {
+			reasons[i] = err.Error()
+			continue
+		}
+		if other, ok := kept[keyHash]; ok && !cert.NotAfter.After(certs[other].NotAfter) {
+			continue
+		}
+		kept[keyHash] = i
+	}
+
+	for i, cert := range certs {
+		if reasons[i] != "" {
+			continue
+		}
+		keyHash, _ := ed25519KeyHash(cert.PublicKey)
+		if k := kept[keyHash]; k != i {
+			reasons[i] = fmt.Sprintf("duplicate key, signature %d is kept", k)
+		}
+	}
+
+	return filterSignatures(descriptor, func(i int, _ *x509.Certificate) string {
+		return reasons[i]
+	})
+}
+
+// filterSignatures removes the certificate and signature pairs for
+// which remove returns a non-empty reason, and returns how many were
+// removed. Indices passed to remove are those of the original
+// descriptor, cert is nil if the certificate cannot be parsed.
+func filterSignatures(descriptor *ospkgs.Descriptor, remove func(i int, cert *x509.Certificate) string) int {
+	certs, sigs := [][]byte{}, [][]byte{}
+
+	for i, certDER := range descriptor.Certificates {
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			cert = nil
+		}
+		if reason := remove(i, cert); reason != "" {
+			stlog.Info("Removing signature %d: %s", i, reason)
+			continue
+		}
+		certs = append(certs, certDER)
+		sigs = append(sigs, descriptor.Signatures[i])
+	}
+
+	removed := len(descriptor.Certificates) - len(certs)
+	descriptor.Certificates, descriptor.Signatures = certs, sigs
+
+	return removed
+}
+
+// updateDescriptor reads the descriptor of an OS package, applies
+// update to it and writes it back, while holding an advisory lock on
+// the descriptor so that concurrent updates are not lost. If
+// archiveHash is not nil, the archive is hashed again before writing,
+// and the update is refused if the archive no longer matches.
+func updateDescriptor(pkgPath string, archiveHash *sigsumCrypto.Hash, update func(*ospkgs.Descriptor) error) error {
+	lock, err := lockDescriptor(pkgPath + ospkgs.DescriptorExt)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	descriptor, err := readDescriptor(pkgPath)
+	if err != nil {
+		return err
+	}
+	if err := update(descriptor); err != nil {
+		return err
+	}
+
+	if archiveHash != nil {
+		hash, err := hashArchive(pkgPath)
+		if err != nil {
+			return err
+		}
+		if hash != *archiveHash {
+			return fmt.Errorf("%w: hash is now %x, expected %x", ErrArchiveChanged, hash, *archiveHash)
+		}
+	}
+
+	return writeDescriptor(pkgPath, descriptor)
+}
+
+// lockDescriptor opens and locks the descriptor at path. Descriptors
+// are replaced by rename, so after waiting for the lock, check that it
+// is still held on the file at path, and retry otherwise.
+func lockDescriptor(path string) (*os.File, error) {
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %q: %w", path, err)
+		}
+
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(path)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+func readDescriptor(pkgPath string) (*ospkgs.Descriptor, error) {
+	b, err := os.ReadFile(pkgPath + ospkgs.DescriptorExt)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err := ospkgs.DescriptorFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(descriptor.Certificates) != len(descriptor.Signatures) {
+		return nil, fmt.Errorf("descriptor has %d certificates but %d signatures",
+			len(descriptor.Certificates), len(descriptor.Signatures))
+	}
+
+	return descriptor, nil
+}
+
+func writeDescriptor(pkgPath string, descriptor *ospkgs.Descriptor) error {
+	b, err := descriptor.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(pkgPath+ospkgs.DescriptorExt, b, defaultFilePerm)
+}
@@ -0,0 +1,116 @@
+package ospkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"reflect"
+	"testing"
+	"time"
+
+	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
+	ospkgs "system-transparency.org/stboot/ospkg"
+)
+
+func TestPruneSignatures(t *testing.T) {
+	now := time.Now()
+
+	rootPub, rootKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newTestCert(t, rootPub, nil, rootKey, now.Add(-time.Hour), now.Add(time.Hour))
+	otherPub, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCert(t, otherPub, nil, otherKey, now.Add(-time.Hour), now.Add(time.Hour))
+
+	leafPub, leafKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestCert(t, leafPub, root, rootKey, now.Add(-time.Hour), now.Add(time.Hour))
+	reissued := newTestCert(t, leafPub, root, rootKey, now.Add(-time.Hour), now.Add(time.Hour))
+	renewed := newTestCert(t, leafPub, root, rootKey, now.Add(-time.Minute), now.Add(2*time.Hour))
+	expired := newTestCert(t, leafPub, root, rootKey, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	foreign := newTestCert(t, otherPub, other, otherKey, now.Add(-time.Hour), now.Add(time.Hour))
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaCert := newTestCert(t, ecdsaKey.Public(), root, rootKey, now.Add(-time.Hour), now.Add(time.Hour))
+
+	archiveHash := sigsumCrypto.HashBytes([]byte("archive"))
+	leafSig := ed25519.Sign(leafKey, archiveHash[:])
+	otherSig := ed25519.Sign(otherKey, archiveHash[:])
+	badSig := ed25519.Sign(leafKey, []byte("other archive"))
+
+	for _, table := range []struct {
+		name      string
+		certs     [][]byte
+		sigs      [][]byte
+		rootCerts *x509.CertPool
+		want      []int // Indices of the signatures kept.
+	}{
+		{
+			name:  "without roots",
+			certs: [][]byte{expired.Raw, leaf.Raw, []byte("garbage"), foreign.Raw, renewed.Raw},
+			sigs:  [][]byte{leafSig, leafSig, leafSig, otherSig, leafSig},
+			want:  []int{3, 4},
+		},
+		{
+			name:      "with roots",
+			certs:     [][]byte{expired.Raw, leaf.Raw, []byte("garbage"), foreign.Raw, renewed.Raw},
+			sigs:      [][]byte{leafSig, leafSig, leafSig, otherSig, leafSig},
+			rootCerts: certPool(root),
+			want:      []int{4},
+		},
+		{
+			name:  "not ed25519",
+			certs: [][]byte{ecdsaCert.Raw, leaf.Raw},
+			sigs:  [][]byte{leafSig, leafSig},
+			want:  []int{1},
+		},
+		{
+			name:  "first duplicate invalid",
+			certs: [][]byte{leaf.Raw, reissued.Raw},
+			sigs:  [][]byte{badSig, leafSig},
+			want:  []int{1},
+		},
+		{
+			name:  "later expiry invalid",
+			certs: [][]byte{leaf.Raw, renewed.Raw, reissued.Raw},
+			sigs:  [][]byte{leafSig, []byte("garbage"), leafSig},
+			want:  []int{0},
+		},
+	} {
+		t.Run(table.name, func(t *testing.T) {
+			descriptor := &ospkgs.Descriptor{Version: 1, Certificates: table.certs, Signatures: table.sigs}
+			var wantCerts, wantSigs [][]byte
+			for _, i := range table.want {
+				wantCerts = append(wantCerts, table.certs[i])
+				wantSigs = append(wantSigs, table.sigs[i])
+			}
+
+			removed := pruneSignatures(descriptor, &archiveHash, table.rootCerts, now)
+			if got, want := removed, len(table.certs)-len(table.want); got != want {
+				t.Errorf("removed %d signatures, want %d", got, want)
+			}
+			if !reflect.DeepEqual(descriptor.Certificates, wantCerts) || !reflect.DeepEqual(descriptor.Signatures, wantSigs) {
+				t.Errorf("kept signatures %x, want %x", descriptor.Signatures, wantSigs)
+			}
+		})
+	}
+}
+
+func certPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return pool
+}
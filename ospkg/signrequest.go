@@ -0,0 +1,203 @@
+package ospkg
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+)
+
+const (
+	signRequestVersion = 1
+	signRequestExt     = ".sign-request.json"
+)
+
+// SignRequest holds what is needed to sign an OS package on another
+// machine, without access to the OS package itself. The signature is
+// an Ed25519 signature over the raw 32 bytes of the archive hash.
+type SignRequest struct {
+	Version     int    `json:"version"`
+	ArchiveHash string `json:"archive_sha256"`
+	ArchiveSize int64  `json:"archive_size"`
+	URL         string `json:"os_pkg_url"`
+	Label       string `json:"label"`
+	// PEM encoded certificate of the key expected to sign.
+	Certificate string `json:"certificate"`
+}
+
+// AttachArgs is a list of arguments that's passed to AttachSignature().
+type AttachArgs struct {
+	PkgPath       string
+	SignaturePath string
+	// Exactly one of RequestPath and CertPath must be set.
+	RequestPath string
+	CertPath    string
+}
+
+// CreateSignRequest writes a sign request for the OS package at
+// pkgPath, to be signed by the key of the certificate at certPath. The
+// request is written to outPath, which defaults to the OS package path
+// with the extension .sign-request.json.
+func CreateSignRequest(certPath, pkgPath, outPath string) error {
+	pkgPath, err := parsePkgPath(pkgPath)
+	if err != nil {
+		return err
+	}
+	if outPath == "" {
+		outPath = pkgPath + signRequestExt
+	}
+
+	cert, err := loadSigningCert(certPath)
+	if err != nil {
+		return err
+	}
+	if _, err := ed25519PublicKeyFromCert(cert); err != nil {
+		return err
+	}
+
+	descriptorBytes, err := os.ReadFile(pkgPath + ospkgs.DescriptorExt)
+	if err != nil {
+		return err
+	}
+	descriptor, err := ospkgs.DescriptorFromBytes(descriptorBytes)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(pkgPath + ospkgs.OSPackageExt)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash, err := sigsumCrypto.HashFile(f)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, stat.Size())
+	if err != nil {
+		return err
+	}
+	m, err := readManifest(zr)
+	if err != nil {
+		return err
+	}
+
+	request := SignRequest{
+		Version:     signRequestVersion,
+		ArchiveHash: hex.EncodeToString(hash[:]),
+		ArchiveSize: stat.Size(),
+		URL:         descriptor.PkgURL,
+		Label:       m.Label,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})),
+	}
+
+	data, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(outPath, append(data, '\n'), defaultFilePerm)
+}
+
+func readSignRequest(path string) (*SignRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var request SignRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("invalid sign request: %w", err)
+	}
+	if request.Version != signRequestVersion {
+		return nil, fmt.Errorf("unsupported sign request version %d", request.Version)
+	}
+
+	return &request, nil
+}
+
+// AttachSignature adds a signature, made elsewhere, and the
+// corresponding certificate to the descriptor of an OS package. The
+// signature must be a raw 64 byte Ed25519 signature over the archive
+// hash, and is checked against the certificate's public key before it
+// is added.
+func AttachSignature(args *AttachArgs) error {
+	if (args.RequestPath == "") == (args.CertPath == "") {
+		return errors.New("exactly one of sign request and certificate must be provided")
+	}
+
+	pkgPath, err := parsePkgPath(args.PkgPath)
+	if err != nil {
+		return err
+	}
+
+	archiveHash, err := hashArchive(pkgPath)
+	if err != nil {
+		return err
+	}
+
+	var cert []byte
+	if args.RequestPath != "" {
+		request, err := readSignRequest(args.RequestPath)
+		if err != nil {
+			return err
+		}
+		if request.ArchiveHash != hex.EncodeToString(archiveHash[:]) {
+			return fmt.Errorf("archive hash %x does not match sign request hash %s, was the archive modified?",
+				archiveHash, request.ArchiveHash)
+		}
+		block, rest := pem.Decode([]byte(request.Certificate))
+		if block == nil || block.Type != "CERTIFICATE" || len(rest) != 0 {
+			return errors.New("invalid certificate in sign request")
+		}
+		cert = block.Bytes
+	} else {
+		cert, err = loadSigningCert(args.CertPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	publicKey, err := ed25519PublicKeyFromCert(cert)
+	if err != nil {
+		return err
+	}
+
+	sig, err := os.ReadFile(args.SignaturePath)
+	if err != nil {
+		return err
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature size %d, expected a raw Ed25519 signature of %d bytes",
+			len(sig), ed25519.SignatureSize)
+	}
+	if !ed25519.Verify(publicKey[:], archiveHash[:], sig) {
+		return errors.New("invalid signature for archive and certificate")
+	}
+
+	keyHash, err := ed25519KeyHash(ed25519.PublicKey(publicKey[:]))
+	if err != nil {
+		return err
+	}
+
+	return updateDescriptor(pkgPath, &archiveHash, func(descriptor *ospkgs.Descriptor) error {
+		if err := checkNotSigned(descriptor, keyHash); err != nil {
+			return err
+		}
+
+		return descriptor.AddSignature(cert, sig)
+	})
+}
@@ -2,56 +2,127 @@ package ospkg
 
 import (
 	"bytes"
+	"context"
+	stdcrypto "crypto"
 	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/policy"
 	"sigsum.org/sigsum-go/pkg/proof"
+	"sigsum.org/sigsum-go/pkg/submit"
 	"sigsum.org/sigsum-go/pkg/types"
 
 	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/stlog"
 	"system-transparency.org/stmgr/keygen"
 )
 
+const (
+	DefaultSigsumTimeout    = 10 * time.Minute
+	DefaultSigsumLogTimeout = 2 * time.Minute
+	sigsumUserAgent         = "stmgr"
+)
+
+// sigsumPollDelay is the delay between polls of a Sigsum log, while
+// waiting for a leaf to be included and cosigned. Zero means the
+// sigsum-go default, tests use a shorter delay.
+var sigsumPollDelay time.Duration
+
+// SubmitArgs is a list of arguments
+// that's passed to SubmitSigsum().
+type SubmitArgs struct {
+	KeyPath    string // Sigsum submission key, any key accepted by keygen.LoadPrivateKey.
+	CertPath   string // Certificate for the submission key.
+	PolicyPath string // Sigsum policy listing logs and witnesses.
+	PkgPath    string
+	// Timeout for the complete submission, and for each log tried.
+	Timeout    time.Duration
+	LogTimeout time.Duration
+}
+
 // AddSigsumProof attaches a Sigsum proof and a corresponding
-// certificate for the sigsum submission key.
-func AddSigsumProof(proofPath, certPath, pkgPath string) error {
+// certificate for the sigsum submission key. If policyPath is set, the
+// complete proof is verified against that Sigsum policy first,
+// otherwise only the leaf is checked.
+func AddSigsumProof(proofPath, certPath, pkgPath, policyPath string) error {
 	pkgPath, err := parsePkgPath(pkgPath)
 	if err != nil {
 		return err
 	}
 
-	archive, err := os.Open(pkgPath + ospkgs.OSPackageExt)
+	archiveHash, err := hashArchive(pkgPath)
 	if err != nil {
 		return err
 	}
-	defer archive.Close()
-	archiveHash, err := crypto.HashFile(archive)
+
+	sigsumProofBytes, err := os.ReadFile(proofPath)
 	if err != nil {
 		return err
 	}
+	var sigsumProof proof.SigsumProof
+	if err := sigsumProof.FromASCII(bytes.NewReader(sigsumProofBytes)); err != nil {
+		return err
+	}
 
-	descriptorBytes, err := os.ReadFile(pkgPath + ospkgs.DescriptorExt)
+	cert, err := loadSigningCert(certPath)
 	if err != nil {
 		return err
 	}
-	descriptor, err := ospkgs.DescriptorFromBytes(descriptorBytes)
+	publicKey, err := ed25519PublicKeyFromCert(cert)
 	if err != nil {
 		return err
 	}
 
-	sigsumProofBytes, err := os.ReadFile(proofPath)
+	keyHash, err := ed25519KeyHash(ed25519.PublicKey(publicKey[:]))
 	if err != nil {
 		return err
 	}
-	var sigsumProof proof.SigsumProof
-	if err := sigsumProof.FromASCII(bytes.NewReader(sigsumProofBytes)); err != nil {
+
+	// Verify leaf signature first, for a clearer error on mismatch.
+	if err := verifyLeaf(&sigsumProof, &publicKey, &archiveHash); err != nil {
 		return err
 	}
 
-	cert, err := keygen.LoadCertBytes(certPath)
+	if policyPath != "" {
+		if err := verifySigsumProof(&sigsumProof, &publicKey, &archiveHash, policyPath); err != nil {
+			return err
+		}
+	} else {
+		stlog.Warn("No Sigsum policy given, inclusion proof and cosignatures not checked")
+	}
+
+	return updateDescriptor(pkgPath, &archiveHash, func(descriptor *ospkgs.Descriptor) error {
+		if err := checkNotSigned(descriptor, keyHash); err != nil {
+			return err
+		}
+
+		return descriptor.AddSignature(cert, sigsumProofBytes)
+	})
+}
+
+// SubmitSigsum submits the archive hash of an OS package to a Sigsum
+// log listed in the policy, trying the next log if one fails, and
+// waits until the log's tree head is cosigned by enough witnesses to
+// satisfy the policy. The resulting proof and the certificate are then
+// added to the descriptor, the same way as by AddSigsumProof.
+func SubmitSigsum(args *SubmitArgs) error {
+	pkgPath, err := parsePkgPath(args.PkgPath)
+	if err != nil {
+		return err
+	}
+
+	archiveHash, err := hashArchive(pkgPath)
+	if err != nil {
+		return err
+	}
+
+	cert, err := loadSigningCert(args.CertPath)
 	if err != nil {
 		return err
 	}
@@ -59,26 +130,140 @@ func AddSigsumProof(proofPath, certPath, pkgPath string) error {
 	if err != nil {
 		return err
 	}
+	keyHash, err := ed25519KeyHash(ed25519.PublicKey(publicKey[:]))
+	if err != nil {
+		return err
+	}
 
-	// Verify leaf signature, without examining the rest of the proof.
-	if got, want := crypto.HashBytes(publicKey[:]), sigsumProof.Leaf.KeyHash; got != want {
-		return fmt.Errorf("public key mismatch, certificate key hash: %x, proof key hash: %x",
-			got, want)
+	// Check the descriptor before submitting anything.
+	descriptor, err := readDescriptor(pkgPath)
+	if err != nil {
+		return err
 	}
-	if !types.VerifyLeafMessage(&publicKey, archiveHash[:], &sigsumProof.Leaf.Signature) {
-		return fmt.Errorf("invalid leaf signature in sigsum proof")
+	if err := checkNotSigned(descriptor, keyHash); err != nil {
+		return err
 	}
 
-	if err := descriptor.AddSignature(cert, sigsumProofBytes); err != nil {
+	signer, err := keygen.LoadPrivateKey(args.KeyPath)
+	if err != nil {
 		return err
 	}
+	defer keygen.CloseSigner(signer)
+	if pub, ok := signer.Public().(ed25519.PublicKey); !ok || !bytes.Equal(pub, publicKey[:]) {
+		return errors.New("submission key does not match certificate")
+	}
 
-	signedDescriptor, err := descriptor.Bytes()
+	sigsumPolicy, err := policy.ReadPolicyFile(args.PolicyPath)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(pkgPath+ospkgs.DescriptorExt, signedDescriptor, defaultFilePerm)
+	timeout, logTimeout := args.Timeout, args.LogTimeout
+	if timeout <= 0 {
+		timeout = DefaultSigsumTimeout
+	}
+	if logTimeout <= 0 {
+		logTimeout = DefaultSigsumLogTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stlog.Info("Submitting archive hash %x to Sigsum log", archiveHash)
+	sigsumProof, err := submit.SubmitMessage(ctx, &submit.Config{
+		Policy:        sigsumPolicy,
+		PerLogTimeout: logTimeout,
+		PollDelay:     sigsumPollDelay,
+		UserAgent:     sigsumUserAgent,
+	}, &sigsumSigner{signer: signer, public: publicKey}, &archiveHash)
+	if err != nil {
+		return fmt.Errorf("sigsum submission failed: %w", err)
+	}
+
+	// Check what the log returned, the same way as for proofs
+	// attached with AddSigsumProof.
+	if err := verifyLeaf(&sigsumProof, &publicKey, &archiveHash); err != nil {
+		return err
+	}
+	if err := verifySigsumProof(&sigsumProof, &publicKey, &archiveHash, args.PolicyPath); err != nil {
+		return err
+	}
+
+	var sigsumProofBytes bytes.Buffer
+	if err := sigsumProof.ToASCII(&sigsumProofBytes); err != nil {
+		return err
+	}
+
+	return updateDescriptor(pkgPath, &archiveHash, func(descriptor *ospkgs.Descriptor) error {
+		if err := checkNotSigned(descriptor, keyHash); err != nil {
+			return err
+		}
+
+		return descriptor.AddSignature(cert, sigsumProofBytes.Bytes())
+	})
+}
+
+// sigsumSigner adapts an Ed25519 crypto.Signer to the Signer
+// interface of sigsum-go, so that any key accepted by
+// keygen.LoadPrivateKey can be used for submission.
+type sigsumSigner struct {
+	signer stdcrypto.Signer
+	public crypto.PublicKey
+}
+
+func (s *sigsumSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *sigsumSigner) Sign(msg []byte) (crypto.Signature, error) {
+	var signature crypto.Signature
+
+	sig, err := s.signer.Sign(rand.Reader, msg, stdcrypto.Hash(0))
+	if err != nil {
+		return signature, err
+	}
+	if len(sig) != len(signature) {
+		return signature, fmt.Errorf("invalid signature size %d", len(sig))
+	}
+	copy(signature[:], sig)
+
+	return signature, nil
+}
+
+// verifySigsumProof verifies the inclusion proof, the log's tree head
+// signature and the witness cosignatures against the policy, the way
+// stboot does at boot, and logs the key hashes of the witnesses that
+// cosigned.
+func verifySigsumProof(sigsumProof *proof.SigsumProof, publicKey *crypto.PublicKey, archiveHash *crypto.Hash,
+	policyPath string,
+) error {
+	sigsumPolicy, err := policy.ReadPolicyFile(policyPath)
+	if err != nil {
+		return err
+	}
+	submitKeys := map[crypto.Hash]crypto.PublicKey{crypto.HashBytes(publicKey[:]): *publicKey}
+	if err := sigsumProof.Verify(archiveHash, submitKeys, sigsumPolicy); err != nil {
+		return fmt.Errorf("invalid sigsum proof: %w", err)
+	}
+
+	for _, cosignature := range sigsumProof.TreeHead.Cosignatures {
+		stlog.Info("Cosigned by witness with key hash %x at %s", cosignature.KeyHash,
+			time.Unix(int64(cosignature.Timestamp), 0).UTC().Format(time.RFC3339))
+	}
+	stlog.Info("Sigsum proof verified")
+
+	return nil
+}
+
+func verifyLeaf(sigsumProof *proof.SigsumProof, publicKey *crypto.PublicKey, archiveHash *crypto.Hash) error {
+	if got, want := crypto.HashBytes(publicKey[:]), sigsumProof.Leaf.KeyHash; got != want {
+		return fmt.Errorf("public key mismatch, certificate key hash: %x, proof key hash: %x",
+			got, want)
+	}
+	if !types.VerifyLeafMessage(publicKey, archiveHash[:], &sigsumProof.Leaf.Signature) {
+		return fmt.Errorf("invalid leaf signature in sigsum proof")
+	}
+
+	return nil
 }
 
 func ed25519PublicKeyFromCert(certDER []byte) (crypto.PublicKey, error) {
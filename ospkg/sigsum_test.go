@@ -0,0 +1,289 @@
+package ospkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/proof"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/server"
+	"sigsum.org/sigsum-go/pkg/types"
+	"system-transparency.org/stmgr/keygen"
+)
+
+func TestSigsumSigner(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var publicKey crypto.PublicKey
+	copy(publicKey[:], pub)
+
+	signer := &sigsumSigner{signer: priv, public: publicKey}
+	if signer.Public() != publicKey {
+		t.Errorf("unexpected public key")
+	}
+
+	msg := crypto.HashBytes([]byte("archive"))
+	sig, err := types.SignLeafMessage(signer, msg[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !types.VerifyLeafMessage(&publicKey, msg[:], &sig) {
+		t.Errorf("invalid leaf signature")
+	}
+}
+
+// testLog is an in-memory Sigsum log, served over HTTP with sigsum-go's
+// server package. It holds at most the one leaf that SubmitSigsum
+// adds, so its tree never grows beyond size one.
+type testLog struct {
+	fail        bool // Fail all add-leaf requests.
+	stall       bool // Accept leaves, but never include them.
+	cosignAfter int  // Number of tree heads served before cosigning.
+
+	signer  crypto.Signer
+	witness crypto.Signer // Cosigns tree heads, if set.
+
+	mu        sync.Mutex
+	leaf      *types.Leaf
+	treeHeads int // Number of tree heads served.
+}
+
+func (l *testLog) keyHash() crypto.Hash {
+	pub := l.signer.Public()
+
+	return crypto.HashBytes(pub[:])
+}
+
+func (l *testLog) AddLeaf(_ context.Context, req requests.Leaf, _ requests.HeaderOptions) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.fail:
+		return false, errors.New("log unavailable")
+	case l.stall:
+		return false, nil
+	}
+	l.leaf = &types.Leaf{
+		Checksum:  crypto.HashBytes(req.Message[:]),
+		Signature: req.Signature,
+		KeyHash:   crypto.HashBytes(req.PublicKey[:]),
+	}
+
+	return true, nil
+}
+
+func (l *testLog) GetTreeHead(context.Context) (types.CosignedTreeHead, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	th := types.TreeHead{RootHash: crypto.HashBytes(nil)}
+	if l.leaf != nil {
+		th = types.TreeHead{Size: 1, RootHash: l.leaf.ToHash()}
+	}
+	sth, err := th.Sign(l.signer)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	cth := types.CosignedTreeHead{SignedTreeHead: sth}
+
+	l.treeHeads++
+	if l.witness != nil && l.leaf != nil && l.treeHeads > l.cosignAfter {
+		logKeyHash := l.keyHash()
+		cosignature, err := th.Cosign(l.witness, &logKeyHash, uint64(time.Now().Unix()))
+		if err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+		cth.Cosignatures = []types.Cosignature{cosignature}
+	}
+
+	return cth, nil
+}
+
+func (l *testLog) GetInclusionProof(_ context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.leaf == nil || req.LeafHash != l.leaf.ToHash() {
+		return types.InclusionProof{}, errors.New("unknown leaf")
+	}
+
+	return types.InclusionProof{LeafIndex: 0}, nil
+}
+
+func (l *testLog) GetConsistencyProof(context.Context, requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	return types.ConsistencyProof{}, errors.New("not implemented")
+}
+
+func (l *testLog) GetLeaves(context.Context, requests.Leaves) ([]types.Leaf, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestSubmitSigsum(t *testing.T) {
+	defer func(delay time.Duration) { sigsumPollDelay = delay }(sigsumPollDelay)
+	sigsumPollDelay = 10 * time.Millisecond
+
+	// Logs are tried in random order, so the log that works is
+	// reached whether or not the other one is tried first.
+	for _, table := range []struct {
+		name       string
+		logs       []*testLog
+		witness    bool // Require a cosignature by a witness.
+		timeout    time.Duration
+		logTimeout time.Duration
+		wantLog    int // Index of the log the proof is from, negative if submission fails.
+	}{
+		{
+			name:    "single log",
+			logs:    []*testLog{{}},
+			wantLog: 0,
+		},
+		{
+			name:    "one log failing",
+			logs:    []*testLog{{fail: true}, {}},
+			wantLog: 1,
+		},
+		{
+			name:       "per-log timeout",
+			logs:       []*testLog{{stall: true}, {}},
+			logTimeout: 200 * time.Millisecond,
+			wantLog:    1,
+		},
+		{
+			name:    "overall timeout",
+			logs:    []*testLog{{stall: true}, {stall: true}},
+			timeout: 300 * time.Millisecond,
+			wantLog: -1,
+		},
+		{
+			name:    "witness wait",
+			logs:    []*testLog{{cosignAfter: 3}},
+			witness: true,
+			wantLog: 0,
+		},
+	} {
+		t.Run(table.name, func(t *testing.T) {
+			dir := t.TempDir()
+			keyPath, certPath := writeTestKey(t, dir, "submit")
+			pkgPath := filepath.Join(dir, "ospkg")
+			writeTestPackage(t, pkgPath, []byte("A dummy OS package"))
+
+			var policyLines []string
+			witnessPub, witness, err := crypto.NewKeyPair()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table.witness {
+				policyLines = append(policyLines, "witness W "+hex.EncodeToString(witnessPub[:]), "quorum W")
+			} else {
+				policyLines = append(policyLines, "quorum none")
+			}
+			for _, log := range table.logs {
+				logPub, signer, err := crypto.NewKeyPair()
+				if err != nil {
+					t.Fatal(err)
+				}
+				log.signer = signer
+				if table.witness {
+					log.witness = witness
+				}
+				srv := httptest.NewServer(server.NewLog(&server.Config{Timeout: 10 * time.Second}, log))
+				t.Cleanup(srv.Close)
+				policyLines = append(policyLines, fmt.Sprintf("log %x %s", logPub[:], srv.URL))
+			}
+			policyPath := filepath.Join(dir, "policy")
+			if err := os.WriteFile(policyPath, []byte(strings.Join(policyLines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			timeout := table.timeout
+			if timeout == 0 {
+				timeout = 10 * time.Second
+			}
+			err = SubmitSigsum(&SubmitArgs{
+				KeyPath:    keyPath,
+				CertPath:   certPath,
+				PolicyPath: policyPath,
+				PkgPath:    pkgPath,
+				Timeout:    timeout,
+				LogTimeout: table.logTimeout,
+			})
+
+			descriptor, derr := readDescriptor(pkgPath)
+			if derr != nil {
+				t.Fatal(derr)
+			}
+			if table.wantLog < 0 {
+				if err == nil {
+					t.Fatal("expected submission to fail")
+				}
+				if len(descriptor.Signatures) != 0 {
+					t.Errorf("got %d signatures after failed submission", len(descriptor.Signatures))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The proof and the certificate are attached.
+			if len(descriptor.Signatures) != 1 {
+				t.Fatalf("got %d signatures, want 1", len(descriptor.Signatures))
+			}
+			if kind := signatureKind(descriptor.Signatures[0]); kind != SignatureKindSigsum {
+				t.Errorf("got %s signature, want %s", kind, SignatureKindSigsum)
+			}
+			certDER, err := keygen.LoadCertBytes(certPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(descriptor.Certificates[0], certDER) {
+				t.Errorf("unexpected certificate attached")
+			}
+			var sigsumProof proof.SigsumProof
+			if err := sigsumProof.FromASCII(bytes.NewReader(descriptor.Signatures[0])); err != nil {
+				t.Fatal(err)
+			}
+			log := table.logs[table.wantLog]
+			if sigsumProof.LogKeyHash != log.keyHash() {
+				t.Errorf("proof is not from log %d", table.wantLog)
+			}
+			if table.witness {
+				if len(sigsumProof.TreeHead.Cosignatures) != 1 {
+					t.Errorf("got %d cosignatures, want 1", len(sigsumProof.TreeHead.Cosignatures))
+				}
+				log.mu.Lock()
+				if log.treeHeads <= log.cosignAfter {
+					t.Errorf("got proof after %d tree heads, before cosigning", log.treeHeads)
+				}
+				log.mu.Unlock()
+			}
+
+			// A second submission by the same key is refused.
+			if err := SubmitSigsum(&SubmitArgs{
+				KeyPath:    keyPath,
+				CertPath:   certPath,
+				PolicyPath: policyPath,
+				PkgPath:    pkgPath,
+				Timeout:    timeout,
+			}); err == nil {
+				t.Errorf("expected repeated submission to be refused")
+			}
+		})
+	}
+}
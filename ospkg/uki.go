@@ -0,0 +1,113 @@
+package ospkg
+
+import (
+	"debug/pe"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PE sections of a unified kernel image, as written by uki.generateUKI.
+const (
+	ukiKernelSection    = ".linux"
+	ukiInitramfsSection = ".initrd"
+	ukiCmdlineSection   = ".cmdline"
+)
+
+// ukiContents holds the boot files found in a unified kernel image.
+type ukiContents struct {
+	kernel    []byte
+	initramfs []byte
+	cmdline   string
+}
+
+func readUKI(path string) (*ukiContents, error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading UKI %q: %w", path, err)
+	}
+	defer f.Close()
+
+	kernel, err := readSection(f, ukiKernelSection)
+	if err != nil {
+		return nil, err
+	}
+	if kernel == nil {
+		return nil, fmt.Errorf("UKI %q has no %s section", path, ukiKernelSection)
+	}
+
+	initramfs, err := readSection(f, ukiInitramfsSection)
+	if err != nil {
+		return nil, err
+	}
+
+	cmdline, err := readSection(f, ukiCmdlineSection)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ukiContents{
+		kernel:    kernel,
+		initramfs: initramfs,
+		// The stub passes the section as is, the trailing
+		// newline added by uki create is not part of the cmdline.
+		cmdline: strings.TrimRight(string(cmdline), "\x00\n"),
+	}, nil
+}
+
+// readSection returns the contents of the named section, or nil if
+// there is no such section.
+func readSection(f *pe.File, name string) ([]byte, error) {
+	s := f.Section(name)
+	if s == nil {
+		return nil, nil
+	}
+
+	data, err := s.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading section %s: %w", name, err)
+	}
+
+	// The raw data is padded to the file alignment, the virtual size
+	// is the size of the contents.
+	if s.VirtualSize != 0 && int64(s.VirtualSize) < int64(len(data)) {
+		data = data[:s.VirtualSize]
+	}
+
+	return data, nil
+}
+
+// writeUKIContents writes kernel and initramfs of a UKI to files in dir
+// and sets up args to use them.
+func writeUKIContents(args *CreateArgs, dir string) error {
+	if args.Kernel != "" || len(args.Initramfs) > 0 || args.InitramfsDir != "" || args.Cmdline != "" {
+		return errors.New("kernel, initramfs and cmdline are taken from the UKI and cannot be set")
+	}
+
+	contents, err := readUKI(args.FromUKI)
+	if err != nil {
+		return err
+	}
+
+	args.Kernel = filepath.Join(dir, "kernel")
+	if err := os.WriteFile(args.Kernel, contents.kernel, defaultFilePerm); err != nil {
+		return err
+	}
+
+	if contents.initramfs != nil {
+		initramfs := filepath.Join(dir, "initramfs")
+		if err := os.WriteFile(initramfs, contents.initramfs, defaultFilePerm); err != nil {
+			return err
+		}
+		args.Initramfs = []string{initramfs}
+	}
+
+	args.Cmdline = contents.cmdline
+	if args.Label == "" {
+		args.Label = "System Transparency OS package " + filepath.Base(args.FromUKI)
+	}
+
+	return nil
+}
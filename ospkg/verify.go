@@ -2,11 +2,11 @@ package ospkg
 
 import (
 	"crypto/x509"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
 
-	sigsumCrypto "sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/policy"
 	"system-transparency.org/stboot/opts"
 	"system-transparency.org/stboot/ospkg"
@@ -21,11 +21,57 @@ const (
 	sigsumPolicyFile = "ospkg_trust_policy"
 )
 
+// VerifyArgs is a list of arguments
+// that's passed to VerifyTrustPolicy() and VerifyRootCerts().
+type VerifyArgs struct {
+	PkgPath string
+	At      time.Time // Point in time to verify at, zero means now.
+	Report  string    // Empty, or one of ReportText and ReportJSON.
+	Out     io.Writer // Where the report is written.
+
+	// Dir, if set, is searched for OS packages to verify, instead
+	// of verifying the one at PkgPath.
+	Dir     string
+	Workers int           // Number of packages verified in parallel.
+	Expiry  time.Duration // Warn about packages not verifiable for this long.
+	// ReportFile, if set, is where the JSON report of a directory
+	// verification is written, in addition to the report on Out.
+	ReportFile string
+
+	// HostConfigPath, if set, names a host configuration whose OS
+	// package pointer must point at the OS package.
+	HostConfigPath string
+}
+
+func (args *VerifyArgs) now() time.Time {
+	if args.At.IsZero() {
+		return time.Now()
+	}
+	stlog.Info("Verifying as of %s", args.At.Format(time.RFC3339))
+
+	return args.At
+}
+
+// verifier holds what is needed to verify OS packages, so that it is
+// only read once when verifying many packages.
+type verifier struct {
+	rootCerts    *x509.CertPool
+	sigsumPolicy *policy.Policy
+	trustPolicy  *trust.Policy
+	now          time.Time
+}
+
+// SigsumPolicyPath returns the path of the Sigsum policy in a Trust
+// policy directory.
+func SigsumPolicyPath(trustPolicyDir string) string {
+	return filepath.Join(trustPolicyDir, sigsumPolicyFile)
+}
+
 // VerifyTrustPolicy verifies an OS package using the provided path to
 // a Trust policy directory
-func VerifyTrustPolicy(trustPolicyDir, pkgPath string) error {
+func VerifyTrustPolicy(trustPolicyDir string, args *VerifyArgs) error {
 	stlog.Info("Using Trust policy directory %q", trustPolicyDir)
-	now := time.Now()
+	now := args.now()
 
 	trustPolicy, err := opts.ReadTrustPolicy(filepath.Join(trustPolicyDir, trustPolicyFile))
 	if err != nil {
@@ -36,53 +82,101 @@ func VerifyTrustPolicy(trustPolicyDir, pkgPath string) error {
 	if err != nil {
 		return err
 	}
-	sigsumPolicy, err := opts.ReadSigsumPolicy(filepath.Join(trustPolicyDir, sigsumPolicyFile))
+	sigsumPolicy, err := opts.ReadSigsumPolicy(SigsumPolicyPath(trustPolicyDir))
 	if err != nil {
 		return err
 	}
 
-	return verify(rootCerts, sigsumPolicy, trustPolicy, now, pkgPath)
+	return (&verifier{rootCerts, sigsumPolicy, trustPolicy, now}).run(args)
 }
 
 // VerifyRootCerts verifies an OS package using the provided path to a
 // file containing root certificate(s).
-func VerifyRootCerts(rootCertsPath, pkgPath string) error {
+func VerifyRootCerts(rootCertsPath string, args *VerifyArgs) error {
 	stlog.Info("Using root certificate(s) only: expecting all found signatures to be valid")
-	now := time.Now()
+	now := args.now()
 
 	rootCerts, err := opts.ReadCertsFile(rootCertsPath, now)
 	if err != nil {
 		return err
 	}
 
-	return verify(rootCerts, nil, &trust.Policy{SignatureThreshold: ospkg.SignatureThresholdAll}, now, pkgPath)
+	return (&verifier{rootCerts, nil, &trust.Policy{SignatureThreshold: ospkg.SignatureThresholdAll}, now}).run(args)
+}
+
+func (v *verifier) run(args *VerifyArgs) error {
+	if args.Dir != "" {
+		return v.verifyDir(args)
+	}
+
+	return v.verify(args)
 }
 
-// verify verifies an OS package using the provided root
+// verify verifies a single OS package using the provided root
 // certificate(s) and Trust Policy
-func verify(rootCerts *x509.CertPool, sigsumPolicy *policy.Policy, trustPolicy *trust.Policy, now time.Time, pkgPath string) error {
-	pkgPath, err := parsePkgPath(pkgPath)
+func (v *verifier) verify(args *VerifyArgs) error {
+	pkgPath, err := parsePkgPath(args.PkgPath)
 	if err != nil {
 		return err
 	}
 
-	r, err := os.Open(pkgPath + ospkg.OSPackageExt)
+	report, err := v.verifyPackage(pkgPath)
 	if err != nil {
 		return err
 	}
-	hash, err := sigsumCrypto.HashFile(r)
+	if args.HostConfigPath != "" {
+		if err := checkHostConfig(args.HostConfigPath, v.trustPolicy, pkgPath); err != nil {
+			report.fail(err)
+		}
+	}
+	for _, warning := range report.Warnings {
+		stlog.Warn("%s", warning)
+	}
+
+	if args.Report != "" {
+		if err := report.write(args.Report, args.Out); err != nil {
+			return err
+		}
+	} else if report.Verified && report.ValidFrom != nil {
+		stlog.Info("OS package is verifiable from %s until %s",
+			report.ValidFrom.Format(time.RFC3339), report.ValidUntil.Format(time.RFC3339))
+	}
+
+	return report.verifyErr
+}
+
+// verifyPackage verifies the OS package at pkgPath, without extension.
+// Verification failures are recorded in the report, errors are only
+// returned if the package cannot be read.
+func (v *verifier) verifyPackage(pkgPath string) (*VerifyReport, error) {
+	hash, err := hashArchive(pkgPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	b, err := os.ReadFile(pkgPath + ospkg.DescriptorExt)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	descriptor, err := ospkg.DescriptorFromBytes(b)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	report := newVerifyReport(descriptor, v.rootCerts, v.sigsumPolicy, v.trustPolicy, &hash, v.now)
+	report.Package = pkgPath
+	report.Verified = true
+	if err := descriptor.Verify(v.rootCerts, v.sigsumPolicy, v.trustPolicy, &hash, v.now); err != nil {
+		report.fail(err)
+	}
+
+	warning, err := checkFetchMethod(v.trustPolicy, descriptor, pkgPath)
+	if err != nil {
+		report.fail(err)
+	}
+	if warning != "" {
+		report.Warnings = append(report.Warnings, warning)
 	}
 
-	return descriptor.Verify(rootCerts, sigsumPolicy, trustPolicy, &hash, now)
+	return report, nil
 }
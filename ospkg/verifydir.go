@@ -0,0 +1,262 @@
+package ospkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/internal/errwriter"
+)
+
+// DefaultExpiry is how long OS packages found by a directory
+// verification are expected to stay verifiable.
+const DefaultExpiry = 30 * 24 * time.Hour
+
+// DirReport summarizes the verification of all OS packages found in a
+// directory. Paths are relative to the directory, and OS packages are
+// named without extension.
+type DirReport struct {
+	Dir                 string          `json:"dir"`
+	Time                time.Time       `json:"time"`
+	Packages            []PackageResult `json:"packages"`
+	Failed              []string        `json:"failed"`
+	ExpiringSoon        []string        `json:"expiring_soon"`
+	OrphanedArchives    []string        `json:"orphaned_archives"`
+	OrphanedDescriptors []string        `json:"orphaned_descriptors"`
+}
+
+type PackageResult struct {
+	Package     string     `json:"ospkg"`
+	Verified    bool       `json:"verified"`
+	Error       string     `json:"error,omitempty"`
+	ValidUntil  *time.Time `json:"valid_until,omitempty"`
+	ExpiresSoon bool       `json:"expires_soon"`
+	Warnings    []string   `json:"warnings,omitempty"`
+}
+
+// verifyDir verifies all OS packages in a directory tree, using a
+// bounded number of workers, and reports the outcome, as a table or
+// as JSON, and optionally also as JSON to a file. An error is returned
+// if any OS package fails verification.
+func (v *verifier) verifyDir(args *VerifyArgs) error {
+	pkgs, orphanedArchives, orphanedDescriptors, err := findPackages(args.Dir)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		stlog.Warn("No OS packages found in %q", args.Dir)
+	}
+
+	workers := args.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	expiry := args.Expiry
+	if expiry == 0 {
+		expiry = DefaultExpiry
+	}
+
+	report := &DirReport{
+		Dir:                 args.Dir,
+		Time:                v.now,
+		Packages:            make([]PackageResult, len(pkgs)),
+		Failed:              []string{},
+		ExpiringSoon:        []string{},
+		OrphanedArchives:    orphanedArchives,
+		OrphanedDescriptors: orphanedDescriptors,
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				report.Packages[i] = v.checkPackage(args.Dir, pkgs[i], expiry)
+			}
+		}()
+	}
+	for i := range pkgs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, result := range report.Packages {
+		if !result.Verified {
+			report.Failed = append(report.Failed, result.Package)
+		} else if result.ExpiresSoon {
+			report.ExpiringSoon = append(report.ExpiringSoon, result.Package)
+		}
+	}
+
+	pretty, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	pretty = append(pretty, '\n')
+	if args.ReportFile != "" {
+		if err := writeFileAtomic(args.ReportFile, pretty, 0o644); err != nil {
+			return err
+		}
+	}
+	if args.Report == ReportJSON {
+		if _, err := args.Out.Write(pretty); err != nil {
+			return err
+		}
+	} else if err := report.writeText(args.Out, expiry); err != nil {
+		return err
+	}
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d of %d OS packages failed verification", len(report.Failed), len(report.Packages))
+	}
+
+	return nil
+}
+
+func (v *verifier) checkPackage(dir, pkg string, expiry time.Duration) PackageResult {
+	result := PackageResult{Package: pkg}
+
+	report, err := v.verifyPackage(filepath.Join(dir, pkg))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Verified = report.Verified
+	result.Error = report.Error
+	result.ValidUntil = report.ValidUntil
+	result.Warnings = report.Warnings
+	result.ExpiresSoon = report.Verified && report.ValidUntil != nil &&
+		report.ValidUntil.Before(v.now.Add(expiry))
+
+	return result
+}
+
+// findPackages lists the archive and descriptor pairs in a directory
+// tree, and the archives and descriptors lacking their counterpart.
+// JSON files that are not OS package descriptors are ignored, as are
+// hidden files, e.g., temporary files of descriptor updates.
+func findPackages(dir string) ([]string, []string, []string, error) {
+	type pair struct{ archive, descriptor bool }
+	found := make(map[string]*pair)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if ext != ospkgs.OSPackageExt && ext != ospkgs.DescriptorExt {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, strings.TrimSuffix(path, ext))
+		if err != nil {
+			return err
+		}
+		p, ok := found[rel]
+		if !ok {
+			p = &pair{}
+			found[rel] = p
+		}
+		if ext == ospkgs.OSPackageExt {
+			p.archive = true
+		} else {
+			p.descriptor = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pkgs, orphanedArchives, orphanedDescriptors := []string{}, []string{}, []string{}
+	for rel, p := range found {
+		switch {
+		case p.archive && p.descriptor:
+			pkgs = append(pkgs, rel)
+		case p.archive:
+			orphanedArchives = append(orphanedArchives, rel+ospkgs.OSPackageExt)
+		case isDescriptor(filepath.Join(dir, rel+ospkgs.DescriptorExt)):
+			orphanedDescriptors = append(orphanedDescriptors, rel+ospkgs.DescriptorExt)
+		default:
+			stlog.Debug("Ignoring %q, not an OS package descriptor", rel+ospkgs.DescriptorExt)
+		}
+	}
+	sort.Strings(pkgs)
+	sort.Strings(orphanedArchives)
+	sort.Strings(orphanedDescriptors)
+
+	return pkgs, orphanedArchives, orphanedDescriptors, nil
+}
+
+func isDescriptor(path string) bool {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	_, err = ospkgs.DescriptorFromBytes(b)
+
+	return err == nil
+}
+
+func (report *DirReport) writeText(out io.Writer, expiry time.Duration) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	w := &errwriter.Writer{W: tw}
+
+	w.Printf("STATUS\tVALID UNTIL\tOS PACKAGE\n")
+	for _, result := range report.Packages {
+		status := "ok"
+		switch {
+		case !result.Verified:
+			status = "FAILED"
+		case result.ExpiresSoon:
+			status = "expiring"
+		}
+		validUntil := "-"
+		if result.ValidUntil != nil {
+			validUntil = result.ValidUntil.UTC().Format(time.RFC3339)
+		}
+		w.Printf("%s\t%s\t%s\n", status, validUntil, result.Package)
+		if result.Error != "" {
+			w.Printf("\t\t  %s\n", result.Error)
+		}
+		for _, warning := range result.Warnings {
+			w.Printf("\t\t  warning: %s\n", warning)
+		}
+	}
+	if w.Err != nil {
+		return w.Err
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	w = &errwriter.Writer{W: out}
+	w.Printf("\n%d OS packages: %d verified, %d failed, %d expiring before %s\n",
+		len(report.Packages), len(report.Packages)-len(report.Failed), len(report.Failed),
+		len(report.ExpiringSoon), report.Time.Add(expiry).UTC().Format(time.RFC3339))
+	for _, path := range report.OrphanedArchives {
+		w.Printf("Orphaned archive:    %s\n", path)
+	}
+	for _, path := range report.OrphanedDescriptors {
+		w.Printf("Orphaned descriptor: %s\n", path)
+	}
+
+	return w.Err
+}
@@ -0,0 +1,126 @@
+package ospkg
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	ospkgs "system-transparency.org/stboot/ospkg"
+	"system-transparency.org/stboot/trust"
+	"system-transparency.org/stmgr/keygen"
+)
+
+func TestFindPackages(t *testing.T) {
+	dir := t.TempDir()
+	descriptor := []byte(`{"version": 1}`)
+	for name, contents := range map[string][]byte{
+		"a.zip":            nil,
+		"a.json":           descriptor,
+		"sub/b.zip":        nil,
+		"sub/b.json":       descriptor,
+		"sub/.b.json.1234": descriptor,
+		"orphan.zip":       nil,
+		"sub/orphan.json":  descriptor,
+		"other.json":       []byte(`{}`),
+		"README":           nil,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, contents, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pkgs, orphanedArchives, orphanedDescriptors, err := findPackages(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "sub/b"}; !reflect.DeepEqual(pkgs, want) {
+		t.Errorf("got packages %q, want %q", pkgs, want)
+	}
+	if want := []string{"orphan.zip"}; !reflect.DeepEqual(orphanedArchives, want) {
+		t.Errorf("got orphaned archives %q, want %q", orphanedArchives, want)
+	}
+	if want := []string{"sub/orphan.json"}; !reflect.DeepEqual(orphanedDescriptors, want) {
+		t.Errorf("got orphaned descriptors %q, want %q", orphanedDescriptors, want)
+	}
+}
+
+func TestVerifyDir(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, certPath := writeTestKey(t, t.TempDir(), "sign")
+	certDER, err := keygen.LoadCertBytes(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCerts := x509.NewCertPool()
+	rootCerts.AddCert(cert)
+
+	// One good, one unsigned and one orphaned package.
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPackage(t, filepath.Join(dir, "good"), []byte("good"))
+	if err := Sign([]string{keyPath}, []string{certPath}, filepath.Join(dir, "good")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPackage(t, filepath.Join(dir, "sub", "bad"), []byte("bad"))
+	if err := os.WriteFile(filepath.Join(dir, "orphan"+ospkgs.OSPackageExt), []byte("orphan"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate expires in an hour.
+	for _, table := range []struct {
+		expiry       time.Duration
+		expiringSoon []string
+	}{
+		{expiry: 2 * time.Hour, expiringSoon: []string{"good"}},
+		{expiry: 30 * time.Minute, expiringSoon: []string{}},
+	} {
+		reportFile := filepath.Join(t.TempDir(), "report.json")
+		v := &verifier{rootCerts, nil, &trust.Policy{SignatureThreshold: 1}, time.Now()}
+		var out bytes.Buffer
+		err := v.verifyDir(&VerifyArgs{Dir: dir, Workers: 2, Expiry: table.expiry, Out: &out, ReportFile: reportFile})
+		if err == nil {
+			t.Errorf("expiry %v: expected failure", table.expiry)
+		}
+
+		data, err := os.ReadFile(reportFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report DirReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Packages) != 2 || report.Packages[0].Package != "good" || !report.Packages[0].Verified ||
+			report.Packages[1].Package != "sub/bad" || report.Packages[1].Verified {
+			t.Errorf("expiry %v: unexpected package results %+v", table.expiry, report.Packages)
+		}
+		if want := []string{"sub/bad"}; !reflect.DeepEqual(report.Failed, want) {
+			t.Errorf("expiry %v: got failed %q, want %q", table.expiry, report.Failed, want)
+		}
+		if !reflect.DeepEqual(report.ExpiringSoon, table.expiringSoon) {
+			t.Errorf("expiry %v: got expiring %q, want %q", table.expiry, report.ExpiringSoon, table.expiringSoon)
+		}
+		if want := []string{"orphan.zip"}; !reflect.DeepEqual(report.OrphanedArchives, want) {
+			t.Errorf("expiry %v: got orphaned archives %q, want %q", table.expiry, report.OrphanedArchives, want)
+		}
+
+		// The table is printed as well.
+		if !bytes.Contains(out.Bytes(), []byte("2 OS packages: 1 verified, 1 failed")) {
+			t.Errorf("expiry %v: unexpected summary:\n%s", table.expiry, out.String())
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package secureboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+	"unicode/utf16"
+
+	"github.com/foxboron/go-uefi/efi/attributes"
+	"github.com/foxboron/go-uefi/efi/signature"
+	"github.com/foxboron/go-uefi/efi/util"
+	"github.com/foxboron/go-uefi/efivar"
+	"github.com/foxboron/go-uefi/pkcs7"
+	"golang.org/x/crypto/cryptobyte"
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/keygen"
+)
+
+// AuthArgs is a list of arguments
+// that's passed to Auth().
+type AuthArgs struct {
+	Variable string // One of VarPK, VarKEK, VarDb and VarDbx.
+	ESL      string // Signature list file, empty for an empty list, e.g., to remove PK.
+	SignKey  string // Key of PK for PK and KEK, or of a KEK for db and dbx.
+	SignCert string
+	Append   bool      // Append to the variable, rather than replacing it.
+	Time     time.Time // Timestamp, must increase for each update; defaults to now.
+	Out      string
+}
+
+// Auth writes a time-based authenticated variable file (.auth), that
+// firmware or, e.g., efi-updatevar accepts to update a Secure Boot
+// variable when signed by the right key.
+func Auth(args *AuthArgs) error {
+	if args.Out == "" {
+		return fmt.Errorf("missing output file")
+	}
+	v, err := lookupVariable(args.Variable)
+	if err != nil {
+		return err
+	}
+	signer, cert, err := loadSigner(args.SignKey, args.SignCert)
+	if err != nil {
+		return err
+	}
+	defer keygen.CloseSigner(signer)
+
+	var data []byte
+	if args.ESL != "" {
+		db, err := LoadDatabase(args.ESL)
+		if err != nil {
+			return err
+		}
+		data = db.Bytes()
+	}
+	if args.Append {
+		v.Attributes |= attributes.EFI_VARIABLE_APPEND_WRITE
+	}
+	t := args.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	auth, err := signVariable(v, data, t, signer, cert)
+	if err != nil {
+		return err
+	}
+	stlog.Info("Signed %s update at %s with %s", v.Name, t.UTC().Format(time.RFC3339), cert.Subject)
+
+	return os.WriteFile(args.Out, auth, 0o644)
+}
+
+// signVariable returns an EFI_VARIABLE_AUTHENTICATION_2 header for
+// the variable data, followed by the data. This is
+// signature.SignEFIVariable, but with the timestamp in UTC, as the UEFI
+// specification requires, and without exiting on errors.
+func signVariable(v efivar.Efivar, data []byte, t time.Time, signer crypto.Signer, cert *x509.Certificate) ([]byte, error) {
+	authvar := signature.NewEFIVariableAuthentication2()
+	t = t.UTC()
+	authvar.Time = util.EFITime{
+		Year:   uint16(t.Year()),
+		Month:  uint8(t.Month()),
+		Day:    uint8(t.Day()),
+		Hour:   uint8(t.Hour()),
+		Minute: uint8(t.Minute()),
+		Second: uint8(t.Second()),
+	}
+
+	// The signed data is the variable name (UCS-2, without
+	// terminator), vendor GUID, attributes, timestamp and data.
+	var signed bytes.Buffer
+	for _, field := range []any{utf16.Encode([]rune(v.Name)), *v.GUID, v.Attributes, authvar.Time, data} {
+		if err := binary.Write(&signed, binary.LittleEndian, field); err != nil {
+			return nil, err
+		}
+	}
+	der, err := pkcs7.SignPKCS7(signer, cert, pkcs7.OIDData, signed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	// The authentication header holds the SignedData, without the
+	// outer ContentInfo.
+	contentInfo := cryptobyte.String(der)
+	_, signedData, err := pkcs7.ParseContentInfo(&contentInfo)
+	if err != nil {
+		return nil, err
+	}
+	authvar.AuthInfo.Header.Length += uint32(len(signedData))
+	authvar.AuthInfo.CertData = signedData
+
+	var out bytes.Buffer
+	authvar.Marshal(&out)
+	out.Write(data)
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,188 @@
+package secureboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/foxboron/go-uefi/authenticode"
+	"github.com/foxboron/go-uefi/efi/signature"
+	"system-transparency.org/stboot/stlog"
+)
+
+var (
+	ErrNotAccepted = errors.New("not accepted by db")
+	ErrForbidden   = errors.New("forbidden by dbx")
+)
+
+// maxChainLength bounds the certificate chains followed from a
+// signature to db.
+const maxChainLength = 8
+
+// CheckArgs is a list of arguments
+// that's passed to Check().
+type CheckArgs struct {
+	UKI string
+	Db  []string // Signature databases, see LoadDatabase.
+	Dbx []string // Optional forbidden signature databases.
+}
+
+// Check checks that firmware with the given db and dbx would accept
+// the UKI: its Authenticode hash is in db, or it has a valid signature
+// by a certificate in db, or chaining to one through the certificates
+// in the signature. Like firmware, certificate validity periods and
+// key usages are ignored. The image is refused if its hash, or any
+// certificate of an accepted signature chain, is in dbx.
+func Check(args *CheckArgs) error {
+	if args.UKI == "" {
+		return errors.New("missing UKI")
+	}
+	if len(args.Db) == 0 {
+		return errors.New("missing db")
+	}
+	db, err := loadDatabases(args.Db)
+	if err != nil {
+		return err
+	}
+	dbx, err := loadDatabases(args.Dbx)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(args.UKI)
+	if err != nil {
+		return err
+	}
+	pe, err := authenticode.Parse(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%q is not a PE file: %w", args.UKI, err)
+	}
+	hash := pe.Hash(crypto.SHA256)
+	stlog.Info("UKI %q: SHA-256 %x", args.UKI, hash)
+	if hasHash(dbx, hash) {
+		return fmt.Errorf("UKI %q: %w: image hash is listed", args.UKI, ErrForbidden)
+	}
+
+	chains, err := signerChains(pe, certificates(db))
+	if err != nil {
+		return fmt.Errorf("UKI %q: %w", args.UKI, err)
+	}
+	forbidden := certificates(dbx)
+	for _, chain := range chains {
+		for _, cert := range chain {
+			for _, f := range forbidden {
+				if cert.Equal(f) {
+					return fmt.Errorf("UKI %q: %w: certificate %s is listed", args.UKI, ErrForbidden, cert.Subject)
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(chains) > 0:
+		chain := chains[0]
+		stlog.Info("UKI signed by %s, trusted through db certificate %s", chain[0].Subject, chain[len(chain)-1].Subject)
+	case hasHash(db, hash):
+		stlog.Info("UKI hash is listed in db")
+	default:
+		return fmt.Errorf("UKI %q: %w", args.UKI, ErrNotAccepted)
+	}
+
+	return nil
+}
+
+func loadDatabases(paths []string) (signature.SignatureDatabase, error) {
+	var db signature.SignatureDatabase
+	for _, path := range paths {
+		d, err := LoadDatabase(path)
+		if err != nil {
+			return nil, err
+		}
+		db.AppendDatabase(&d)
+	}
+
+	return db, nil
+}
+
+// signerChains returns, for each valid signature on the PE that chains
+// to a trusted certificate, the chain from signer to trusted
+// certificate.
+func signerChains(pe *authenticode.PECOFFBinary, trusted []*x509.Certificate) ([][]*x509.Certificate, error) {
+	sigs, err := pe.Signatures()
+	if err != nil {
+		return nil, err
+	}
+	if len(sigs) == 0 {
+		stlog.Info("UKI is not signed")
+	}
+
+	var chains [][]*x509.Certificate
+	// A trusted certificate may have signed directly, without
+	// being included in the signature.
+	for _, cert := range trusted {
+		if ok, _ := pe.Verify(cert); ok {
+			chains = append(chains, []*x509.Certificate{cert})
+		}
+	}
+	for _, sig := range sigs {
+		auth, err := authenticode.ParseAuthenticode(sig.Certificate)
+		if err != nil {
+			stlog.Warn("Skipping invalid signature: %v", err)
+			continue
+		}
+		for _, cert := range auth.Pkcs.Certs {
+			if !auth.Pkcs.HasCertificate(cert) {
+				continue
+			}
+			if ok, _ := pe.Verify(cert); !ok {
+				continue
+			}
+			if chain := chainTo(cert, auth.Pkcs.Certs, trusted); chain != nil {
+				chains = append(chains, chain)
+			}
+		}
+	}
+
+	return chains, nil
+}
+
+// chainTo follows issuers of cert, through the intermediate
+// certificates, until a trusted certificate.
+func chainTo(cert *x509.Certificate, intermediates, trusted []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{cert}
+	for len(chain) < maxChainLength {
+		for _, t := range trusted {
+			if cert.Equal(t) {
+				return chain
+			}
+			if issuedBy(cert, t) {
+				return append(chain, t)
+			}
+		}
+		var issuer *x509.Certificate
+		for _, c := range intermediates {
+			if !c.Equal(cert) && issuedBy(cert, c) {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			return nil
+		}
+		cert = issuer
+		chain = append(chain, cert)
+	}
+
+	return nil
+}
+
+// issuedBy checks the signature of cert by issuer. Unlike
+// CheckSignatureFrom, the issuer need not be a CA, as firmware doesn't
+// check that either.
+func issuedBy(cert, issuer *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, issuer.RawSubject) &&
+		issuer.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
@@ -0,0 +1,78 @@
+package secureboot
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/foxboron/go-uefi/authenticode"
+	"github.com/foxboron/go-uefi/efi/signature"
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/keygen"
+)
+
+// ESLArgs is a list of arguments
+// that's passed to ESL().
+type ESLArgs struct {
+	Certs  []string // PEM certificate files, each may hold several certificates.
+	Hashes []string // PE files, typically UKIs, listed by Authenticode SHA-256 hash.
+	Owner  string   // Owner GUID of the entries, random if empty.
+	Out    string
+}
+
+// ESL writes an EFI signature list file (.esl) with certificates and
+// image hashes, for enrolment in PK, KEK, db or dbx.
+func ESL(args *ESLArgs) error {
+	if args.Out == "" {
+		return errors.New("missing output file")
+	}
+	if len(args.Certs) == 0 && len(args.Hashes) == 0 {
+		return errors.New("no certificates or images to list")
+	}
+	owner, err := parseOwner(args.Owner)
+	if err != nil {
+		return err
+	}
+
+	var db signature.SignatureDatabase
+	for _, path := range args.Certs {
+		certs, err := keygen.LoadCertChain(path)
+		if err != nil {
+			return err
+		}
+		for _, der := range certs {
+			if err := db.Append(signature.CERT_X509_GUID, *owner, der); err != nil {
+				return fmt.Errorf("%q: %w", path, err)
+			}
+		}
+	}
+	for _, path := range args.Hashes {
+		hash, err := imageHash(path)
+		if err != nil {
+			return err
+		}
+		stlog.Info("Image %q: SHA-256 %x", path, hash)
+		if err := db.Append(signature.CERT_SHA256_GUID, *owner, hash); err != nil {
+			return fmt.Errorf("%q: %w", path, err)
+		}
+	}
+
+	return os.WriteFile(args.Out, db.Bytes(), 0o644)
+}
+
+// imageHash returns the Authenticode SHA-256 hash of a PE file, the
+// hash that db and dbx entries for the image list.
+func imageHash(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pe, err := authenticode.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a PE file: %w", path, err)
+	}
+
+	return pe.Hash(crypto.SHA256), nil
+}
@@ -0,0 +1,119 @@
+package secureboot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/keygen"
+)
+
+// OwnerFile is the file in the key directory with the owner GUID of
+// the generated signature lists, for use with later updates.
+const OwnerFile = "owner.guid"
+
+// KeysArgs is a list of arguments
+// that's passed to Keys().
+type KeysArgs struct {
+	Dir       string
+	Algorithm string // One of the RSA algorithms of keygen.GenerateKey, default rsa2048.
+	Owner     string // Owner GUID, random if empty.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Keys generates a Secure Boot key hierarchy in a directory: a
+// self-signed certificate and key for each of PK, KEK and db, as
+// NAME.pem and NAME.key, and the signature lists NAME.esl and
+// authenticated variables NAME.auth to enrol them. PK.auth and KEK.auth
+// are signed by PK, and db.auth by KEK, so they must be enrolled in the
+// order db, KEK and PK, as enrolling PK leaves setup mode. Existing
+// files are never overwritten.
+func Keys(args *KeysArgs) error {
+	if args.Dir == "" {
+		return errors.New("missing output directory")
+	}
+	algorithm := args.Algorithm
+	if algorithm == "" {
+		algorithm = keygen.AlgorithmRSA2048
+	}
+	switch algorithm {
+	case keygen.AlgorithmRSA2048, keygen.AlgorithmRSA3072, keygen.AlgorithmRSA4096:
+	default:
+		return fmt.Errorf("algorithm %q: %w", algorithm, ErrNotRSA)
+	}
+	if algorithm != keygen.AlgorithmRSA2048 {
+		stlog.Warn("Some firmware only supports %s keys", keygen.AlgorithmRSA2048)
+	}
+	// All entries get the same owner, recorded for later updates.
+	owner := args.Owner
+	if owner == "" {
+		owner = uuid.NewString()
+	}
+	if _, err := parseOwner(owner); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(args.Dir, 0o755); err != nil {
+		return err
+	}
+	path := func(name string) string { return filepath.Join(args.Dir, name) }
+	names := []string{VarPK, VarKEK, VarDb}
+	files := []string{OwnerFile}
+	for _, name := range names {
+		files = append(files, name+".pem", name+".key", name+".esl", name+".auth")
+	}
+	for _, file := range files {
+		if _, err := os.Lstat(path(file)); err == nil {
+			return fmt.Errorf("%q already exists", path(file))
+		}
+	}
+
+	if err := os.WriteFile(path(OwnerFile), []byte(owner+"\n"), 0o644); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := keygen.Certificate(&keygen.CertificateArgs{
+			IsCa:      true,
+			Algorithm: algorithm,
+			NotBefore: args.NotBefore,
+			NotAfter:  args.NotAfter,
+			CertOut:   path(name + ".pem"),
+			KeyOut:    path(name + ".key"),
+		}); err != nil {
+			return fmt.Errorf("generating %s: %w", name, err)
+		}
+		if err := ESL(&ESLArgs{
+			Certs: []string{path(name + ".pem")},
+			Owner: owner,
+			Out:   path(name + ".esl"),
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Use a single timestamp, later updates must have a later one.
+	now := time.Now()
+	for _, auth := range []struct{ name, signer string }{
+		{VarPK, VarPK},
+		{VarKEK, VarPK},
+		{VarDb, VarKEK},
+	} {
+		if err := Auth(&AuthArgs{
+			Variable: auth.name,
+			ESL:      path(auth.name + ".esl"),
+			SignKey:  path(auth.signer + ".key"),
+			SignCert: path(auth.signer + ".pem"),
+			Time:     now,
+			Out:      path(auth.name + ".auth"),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
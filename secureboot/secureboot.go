@@ -0,0 +1,234 @@
+// Package secureboot manages the UEFI Secure Boot key hierarchy used to
+// boot signed UKIs: the platform key (PK), key exchange keys (KEK) and
+// the signature databases db and dbx.
+package secureboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/foxboron/go-uefi/efi/signature"
+	"github.com/foxboron/go-uefi/efi/util"
+	"github.com/foxboron/go-uefi/efivar"
+	"github.com/google/uuid"
+	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/keygen"
+)
+
+// Secure Boot variables.
+const (
+	VarPK  = "PK"
+	VarKEK = "KEK"
+	VarDb  = "db"
+	VarDbx = "dbx"
+)
+
+var (
+	ErrVariable      = errors.New("unknown Secure Boot variable")
+	ErrNotRSA        = errors.New("only RSA keys are supported for Secure Boot")
+	ErrSignatureList = errors.New("invalid EFI signature list")
+)
+
+var variables = map[string]efivar.Efivar{
+	VarPK:  efivar.PK,
+	VarKEK: efivar.KEK,
+	VarDb:  efivar.Db,
+	VarDbx: efivar.Dbx,
+}
+
+func lookupVariable(name string) (efivar.Efivar, error) {
+	v, ok := variables[name]
+	if !ok {
+		return efivar.Efivar{}, fmt.Errorf("%w %q, must be one of %s, %s, %s and %s",
+			ErrVariable, name, VarPK, VarKEK, VarDb, VarDbx)
+	}
+
+	return v, nil
+}
+
+// parseOwner parses the owner GUID of signature list entries. If
+// empty, a random GUID is generated.
+func parseOwner(owner string) (*util.EFIGUID, error) {
+	if owner == "" {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+		stlog.Info("Using owner GUID %s", id)
+
+		return util.StringToGUID(id.String()), nil
+	}
+	id, err := uuid.Parse(owner)
+	if err != nil {
+		return nil, fmt.Errorf("invalid owner GUID %q: %w", owner, err)
+	}
+
+	return util.StringToGUID(id.String()), nil
+}
+
+// loadSigner loads a private key and the corresponding certificate.
+// The signer is to be released with keygen.CloseSigner.
+// The go-uefi PKCS#7 signatures are RSA only, and so is most firmware.
+func loadSigner(keyFile, certFile string) (crypto.Signer, *x509.Certificate, error) {
+	if keyFile == "" || certFile == "" {
+		return nil, nil, errors.New("both signing key and certificate are required")
+	}
+	certDER, err := keygen.LoadCertBytes(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate %q: %w", certFile, err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("certificate %q: %w", certFile, ErrNotRSA)
+	}
+	signer, err := keygen.LoadPrivateKey(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !pub.Equal(signer.Public()) {
+		keygen.CloseSigner(signer)
+		return nil, nil, fmt.Errorf("key %q does not match certificate %q", keyFile, certFile)
+	}
+
+	return signer, cert, nil
+}
+
+// LoadDatabase loads a signature database, from a file with EFI
+// signature lists, an authenticated variable (.auth) file, or a file
+// with PEM certificates.
+func LoadDatabase(path string) (signature.SignatureDatabase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		var db signature.SignatureDatabase
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			if err := db.Append(signature.CERT_X509_GUID, util.EFIGUID{}, block.Bytes); err != nil {
+				return nil, err
+			}
+		}
+		if len(db) == 0 {
+			return nil, fmt.Errorf("no certificates in %q", path)
+		}
+
+		return db, nil
+	}
+
+	if n, ok := authHeaderSize(data); ok {
+		data = data[n:]
+	}
+	if err := checkSignatureLists(data); err != nil {
+		return nil, fmt.Errorf("%q: %w", path, err)
+	}
+	db, err := signature.ReadSignatureDatabase(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", path, err)
+	}
+
+	return db, nil
+}
+
+// authHeaderSize returns the size of the EFI_VARIABLE_AUTHENTICATION_2
+// header, if data starts with one.
+func authHeaderSize(data []byte) (int, bool) {
+	const (
+		offsetLength   = util.SizeofEFITime
+		offsetCertType = offsetLength + 6
+		offsetGUID     = offsetCertType + 2
+	)
+	if len(data) < offsetGUID+int(util.SizeofEFIGUID) {
+		return 0, false
+	}
+	if signature.WINCertType(binary.LittleEndian.Uint16(data[offsetCertType:])) != signature.WIN_CERT_TYPE_EFI_GUID ||
+		!bytes.Equal(data[offsetGUID:offsetGUID+int(util.SizeofEFIGUID)], guidBytes(signature.EFI_CERT_TYPE_PKCS7_GUID)) {
+		return 0, false
+	}
+	n := offsetLength + int(binary.LittleEndian.Uint32(data[offsetLength:]))
+	if n > len(data) {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// checkSignatureLists checks the list and entry sizes of EFI signature
+// lists, which go-uefi trusts when parsing.
+func checkSignatureLists(data []byte) error {
+	const headerSize = int(signature.SizeofSignatureList)
+	for len(data) > 0 {
+		if len(data) < headerSize {
+			return fmt.Errorf("%w: truncated header", ErrSignatureList)
+		}
+		listSize := int(binary.LittleEndian.Uint32(data[util.SizeofEFIGUID:]))
+		extraSize := int(binary.LittleEndian.Uint32(data[util.SizeofEFIGUID+4:]))
+		entrySize := int(binary.LittleEndian.Uint32(data[util.SizeofEFIGUID+8:]))
+		if listSize > len(data) || listSize < headerSize+extraSize || entrySize <= int(util.SizeofEFIGUID) ||
+			(listSize-headerSize-extraSize)%entrySize != 0 {
+			return fmt.Errorf("%w: inconsistent sizes", ErrSignatureList)
+		}
+		data = data[listSize:]
+	}
+
+	return nil
+}
+
+// guidBytes returns a GUID in its EFI (mixed endian) encoding.
+func guidBytes(guid util.EFIGUID) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, guid)
+
+	return buf.Bytes()
+}
+
+// certificates returns the X.509 certificates of a signature database.
+// Entries that fail to parse are skipped with a warning.
+func certificates(db signature.SignatureDatabase) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, list := range db {
+		if !util.CmpEFIGUID(list.SignatureType, signature.CERT_X509_GUID) {
+			continue
+		}
+		for _, entry := range list.Signatures {
+			cert, err := x509.ParseCertificate(entry.Data)
+			if err != nil {
+				stlog.Warn("Skipping invalid certificate in signature list: %v", err)
+				continue
+			}
+			certs = append(certs, cert)
+		}
+	}
+
+	return certs
+}
+
+// hasHash reports whether a signature database lists a SHA-256 hash.
+func hasHash(db signature.SignatureDatabase, hash []byte) bool {
+	for _, list := range db {
+		if !util.CmpEFIGUID(list.SignatureType, signature.CERT_SHA256_GUID) {
+			continue
+		}
+		for _, entry := range list.Signatures {
+			if bytes.Equal(entry.Data, hash) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package secureboot
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/foxboron/go-uefi/authenticode"
+	"github.com/foxboron/go-uefi/efi/signature"
+	"system-transparency.org/stmgr/keygen"
+)
+
+func TestKeysAndCheck(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	now := time.Now()
+	if err := Keys(&KeysArgs{Dir: dir, NotBefore: now, NotAfter: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Keys(&KeysArgs{Dir: dir, NotBefore: now, NotAfter: now.Add(time.Hour)}); err == nil {
+		t.Error("expected error for existing keys")
+	}
+
+	// The .auth files hold the signature lists, signed by the
+	// right key.
+	for _, auth := range []struct{ name, signer string }{{VarPK, VarPK}, {VarKEK, VarPK}, {VarDb, VarKEK}} {
+		data, err := os.ReadFile(path(auth.name + ".auth"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		esl, err := os.ReadFile(path(auth.name + ".esl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasSuffix(data, esl) {
+			t.Errorf("%s.auth does not end with %s.esl", auth.name, auth.name)
+		}
+		authvar, err := signature.ReadEFIVariableAuthencation2(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := authvar.Verify(loadCert(t, path(auth.signer+".pem"))); !ok || err != nil {
+			t.Errorf("%s.auth not signed by %s: %v", auth.name, auth.signer, err)
+		}
+	}
+
+	uki := path("stub.efi")
+	signImage(t, "../uki/stub/linuxx64.efi.stub", uki, path("db.key"), path("db.pem"))
+
+	if err := Check(&CheckArgs{UKI: uki, Db: []string{path("db.auth")}}); err != nil {
+		t.Errorf("check against db: %v", err)
+	}
+	if err := Check(&CheckArgs{UKI: uki, Db: []string{path("KEK.esl")}}); !errors.Is(err, ErrNotAccepted) {
+		t.Errorf("check against KEK: got %v, want %v", err, ErrNotAccepted)
+	}
+
+	if err := ESL(&ESLArgs{Hashes: []string{uki}, Out: path("dbx.esl")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dbx := range []string{path("dbx.esl"), path("db.pem")} {
+		err := Check(&CheckArgs{UKI: uki, Db: []string{path("db.esl")}, Dbx: []string{dbx}})
+		if !errors.Is(err, ErrForbidden) {
+			t.Errorf("check with dbx %q: got %v, want %v", dbx, err, ErrForbidden)
+		}
+	}
+}
+
+func TestLoadDatabaseInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bad.esl")
+	// A list header with an entry size of zero.
+	if err := os.WriteFile(file, append(guidBytes(signature.CERT_X509_GUID), make([]byte, 12)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDatabase(file); !errors.Is(err, ErrSignatureList) {
+		t.Errorf("got %v, want %v", err, ErrSignatureList)
+	}
+}
+
+func loadCert(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	der, err := keygen.LoadCertBytes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func signImage(t *testing.T, in, out, keyFile, certFile string) {
+	t.Helper()
+	data, err := os.ReadFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe, err := authenticode.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, cert, err := loadSigner(keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pe.Sign(signer, cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(out, pe.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
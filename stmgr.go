@@ -49,6 +49,10 @@ COMMANDS:
 		Output formats:
 			* ISO
 
+	secureboot:
+		Manage the Secure Boot keys and signature databases
+		that UKIs are checked against.
+
 Use 'stmgr <COMMAND> -help' for more info.
 `
 
@@ -71,6 +75,8 @@ Use 'stmgr <COMMAND> -help' for more info.
 		return ukiArg(args)
 	case "keygen":
 		return keygenArg(args)
+	case "secureboot":
+		return securebootArg(args)
 	default:
 		// Display usage on unknown command
 		log.Print(usage)
@@ -118,10 +124,22 @@ func ospkgArg(args []string) error {
 		return eval.OspkgCreate(args[flagsCallPosition:])
 	case "sign":
 		return eval.OspkgSign(args[flagsCallPosition:])
+	case "sign-request":
+		return eval.OspkgSignRequest(args[flagsCallPosition:])
+	case "attach-signature":
+		return eval.OspkgAttachSignature(args[flagsCallPosition:])
 	case "sigsum":
 		return eval.OspkgSigsum(args[flagsCallPosition:])
 	case "verify":
 		return eval.OspkgVerify(args[flagsCallPosition:])
+	case "inspect":
+		return eval.OspkgInspect(args[flagsCallPosition:])
+	case "extract":
+		return eval.OspkgExtract(args[flagsCallPosition:])
+	case "diff":
+		return eval.OspkgDiff(args[flagsCallPosition:])
+	case "signatures":
+		return signaturesArg(args)
 	default:
 		// Display usage on unknown subcommand
 		log.Print(`SUBCOMMANDS:
@@ -132,13 +150,37 @@ func ospkgArg(args []string) error {
 	sign:
 		Sign the provided OS package with your private key.
 
+	sign-request:
+		Write a sign request for the provided OS package,
+		to sign it on another machine.
+
+	attach-signature:
+		Add a signature made elsewhere and corresponding cert
+		to the OS package descriptor.
+
 	sigsum:
 		Add a Sigsum proof of logging and corresponding cert
 		to the OS package descriptor.
 
 	verify:
-		Verify the provided OS package using a Trust policy directory,
-		or just a file containing root certificate(s).
+		Verify the provided OS package, or all OS packages in
+		a directory, using a Trust policy directory, or just
+		a file containing root certificate(s).
+
+	inspect:
+		Show the descriptor, signatures and archive contents
+		of the provided OS package.
+
+	extract:
+		Extract kernel, initramfs and kernel command line
+		from the provided OS package.
+
+	diff:
+		Show what changed between two OS packages.
+
+	signatures:
+		List, remove or prune the signatures of an
+		OS package descriptor.
 
 Use 'stmgr ospkg <SUBCOMMAND> -help' for more info.
 `)
@@ -147,6 +189,39 @@ Use 'stmgr ospkg <SUBCOMMAND> -help' for more info.
 	}
 }
 
+// Check for ospkg signatures subcommands, one position further
+// than usual.
+func signaturesArg(args []string) error {
+	if len(args) > flagsCallPosition {
+		switch args[flagsCallPosition] {
+		case "list":
+			return eval.OspkgSignaturesList(args[flagsCallPosition+1:])
+		case "remove":
+			return eval.OspkgSignaturesRemove(args[flagsCallPosition+1:])
+		case "prune":
+			return eval.OspkgSignaturesPrune(args[flagsCallPosition+1:])
+		}
+	}
+
+	// Display usage on unknown subcommand
+	log.Print(`SUBCOMMANDS:
+	list:
+		List the signatures of the provided OS package.
+
+	remove:
+		Remove signatures by index or key hash.
+
+	prune:
+		Remove signatures with expired certificates, or not
+		chaining to the provided root certificate(s), and
+		duplicate signatures by the same key.
+
+Use 'stmgr ospkg signatures <SUBCOMMAND> -help' for more info.
+`)
+
+	return nil
+}
+
 func ukiArg(args []string) error {
 	switch args[subcommandCallPosition] {
 	case "create":
@@ -173,12 +248,23 @@ func keygenArg(args []string) error {
 	switch args[subcommandCallPosition] {
 	case "certificate":
 		return eval.KeygenCertificate(args[flagsCallPosition:])
+	case "keypair":
+		return eval.KeygenKeypair(args[flagsCallPosition:])
+	case "inspect":
+		return eval.KeygenInspect(args[flagsCallPosition:])
 	default:
 		// Display usage on unknown subcommand
 		log.Print(`SUBCOMMANDS:
 	certificate:
 		Generate certificates for signing OS packages
-		using ED25519 keys.
+		using ED25519 keys, or for Secure Boot signing
+		using RSA keys.
+	keypair:
+		Generate a key pair, ED25519 by default, in PEM or
+		OpenSSH format, and print its key hash.
+	inspect:
+		Show key hashes, validity and usage of certificates
+		and keys, and whether certificates chain to a root.
 
 Use 'stmgr keygen <SUBCOMMAND> -help' for more info.
 `)
@@ -186,3 +272,40 @@ Use 'stmgr keygen <SUBCOMMAND> -help' for more info.
 		return nil
 	}
 }
+
+// Check for secureboot subcommands.
+func securebootArg(args []string) error {
+	switch args[subcommandCallPosition] {
+	case "keys":
+		return eval.SecurebootKeys(args[flagsCallPosition:])
+	case "esl":
+		return eval.SecurebootESL(args[flagsCallPosition:])
+	case "auth":
+		return eval.SecurebootAuth(args[flagsCallPosition:])
+	case "check":
+		return eval.SecurebootCheck(args[flagsCallPosition:])
+	default:
+		// Display usage on unknown subcommand
+		log.Print(`SUBCOMMANDS:
+	keys:
+		Generate a PK, KEK and db key hierarchy, with
+		signature lists and .auth files to enrol it.
+
+	esl:
+		Write an EFI signature list of certificates, or of
+		hashes of UKIs, e.g., to revoke them in dbx.
+
+	auth:
+		Sign a signature list as a time-based authenticated
+		update of PK, KEK, db or dbx.
+
+	check:
+		Check that a signed UKI would be accepted by a db,
+		and not refused by a dbx.
+
+Use 'stmgr secureboot <SUBCOMMAND> -help' for more info.
+`)
+
+		return nil
+	}
+}
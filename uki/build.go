@@ -2,6 +2,7 @@ package uki
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"crypto/x509"
 	"errors"
 	"flag"
@@ -71,8 +72,10 @@ func Create(args []string) error {
 	stub := ukiCmd.String("stub", "", "UKI stub location (defaults to an embedded stub)")
 	sbat := ukiCmd.String("sbat", "", "SBAT metadata")
 	appendSbat := ukiCmd.Bool("append-sbat", false, "Append SBAT metadata to the existing section (default: false)")
+	noCheck := ukiCmd.Bool("no-check", false, "do not check the kernel image and lint the cmdline (default: false)")
 	signCert := ukiCmd.String("signcert", "", "Certificate corresponding to the private key (a file in PEM format)")
-	signKey := ukiCmd.String("signkey", "", "Private key for signing the uki for Secure Boot (a file in PEM format)")
+	signKey := ukiCmd.String("signkey", "", "Private key for signing the uki for Secure Boot"+
+		" (a file in PEM format, or a pkcs11: URI or signer: spec, see the manual)")
 
 	if err := ukiCmd.Parse(args); err != nil {
 		return err
@@ -131,6 +134,12 @@ func Create(args []string) error {
 		return fmt.Errorf("both -signcert and -signkey are required for signing UKI")
 	}
 
+	if !*noCheck {
+		if err := checkKernel(*kernel, *cmdline); err != nil {
+			return fmt.Errorf("%w (use -no-check to create the UKI anyway)", err)
+		}
+	}
+
 	uki := &UKI{}
 
 	defer uki.Cleanup()
@@ -225,6 +234,7 @@ func signPE(keyFileName, certFileName, peFileName string) error {
 	if err != nil {
 		return fmt.Errorf("LoadPrivateKey failed: %w", err)
 	}
+	defer keygen.CloseSigner(signer)
 	certDER, err := keygen.LoadCertBytes(certFileName)
 	if err != nil {
 		return fmt.Errorf("LoadCertBytes failed: %w", err)
@@ -233,6 +243,10 @@ func signPE(keyFileName, certFileName, peFileName string) error {
 	if err != nil {
 		return fmt.Errorf("invalid x509 certificate: %w", err)
 	}
+	// go-uefi only produces RSA Authenticode signatures.
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+		return fmt.Errorf("only RSA keys are supported for Secure Boot signing")
+	}
 
 	// pe.Sign both signs and returns the signature
 	if _, err := pe.Sign(signer, cert); err != nil {
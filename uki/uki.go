@@ -11,6 +11,7 @@ import (
 	"os/exec"
 
 	"system-transparency.org/stboot/stlog"
+	"system-transparency.org/stmgr/kernel"
 )
 
 // Adapted from https://github.com/Foxboron/sbctl/blob/master/bundles.go
@@ -90,6 +91,29 @@ func (u *UKI) Cleanup() {
 	os.Remove(u.osRelease)
 }
 
+// checkKernel checks that the kernel is a Linux kernel the UKI stub can
+// start, either as an EFI application or with the EFI handover
+// protocol, and lints the cmdline.
+func checkKernel(path, cmdline string) error {
+	if path == "" {
+		return fmt.Errorf("no kernel specified")
+	}
+
+	h, err := kernel.Check(path, cmdline)
+	if err != nil {
+		return err
+	}
+	stlog.Info("Kernel: %s", h)
+	if h.Version != "" {
+		stlog.Info("Kernel version: %s", h.Version)
+	}
+	if !h.EFIStub && !h.EFIHandover {
+		return fmt.Errorf("kernel %q has no EFI stub, and cannot be started from a UKI", path)
+	}
+
+	return nil
+}
+
 // Check if binary has an existing SBAT section.
 func hasSBAT(stub string) bool {
 	out, err := exec.Command("objdump", "-h", stub).Output()